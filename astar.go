@@ -0,0 +1,203 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+)
+
+// node is an entry in the priority queue searched by solveAStar.
+type node struct {
+	s     state
+	key   string // s.String()
+	g     int    // the length of the shortest known path from the initial state to s
+	bound int    // g plus a lower bound on the length of the path from s to the end state
+}
+
+// queue is a priority queue of nodes, ordered by bound. It implements heap.Interface.
+type queue []node
+
+func (q queue) Len() int            { return len(q) }
+func (q queue) Less(i, j int) bool  { return q[i].bound < q[j].bound }
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(node)) }
+func (q *queue) Pop() interface{} {
+	n := (*q)[len(*q)-1]
+	*q = (*q)[:len(*q)-1]
+	return n
+}
+
+// parent records the move by which the shortest known path to a state was reached, and the key of the state it was made from.
+type parent struct {
+	key  string
+	move step
+}
+
+// solveAStar returns the length of the shortest path from s to the end state, like solve, but searches the states in order of a lower bound
+// on the length of the complete path through each (the A* algorithm) rather than recursively. Whenever a complete path is found, it is
+// recorded in sv.best, and the search stops as soon as no state in the queue has a lower bound less than sv.best, which proves that sv.best
+// is optimal. If ctx is done before then, ctx's error is returned, and sv.best holds the best path found so far, which may not be optimal.
+// The moves of the best path are recorded in sv.steps, which is returned by sv.trace.
+func (sv *solver) solveAStar(ctx context.Context, s state) (int, error) {
+	sv.ctx = ctx
+	sv.starts = s.cells
+	start := s.String()
+	dist := map[string]int{start: 0}
+	parents := make(map[string]parent)
+	var bestKey string
+	q := &queue{{s: s, key: start, g: 0, bound: sv.lowerBound(s)}}
+	for q.Len() > 0 {
+		current := heap.Pop(q).(node)
+
+		// If even the most promising state can't lead to a path shorter than the best found so far, the best is optimal.
+		if sv.best > 0 && current.bound >= sv.best {
+			break
+		}
+		if d, ok := dist[current.key]; ok && d < current.g {
+			continue
+		}
+		if sv.calls++; sv.calls%4096 == 0 && ctx.Err() != nil {
+			sv.steps = sv.pathTo(bestKey, parents)
+			return 0, ctx.Err()
+		}
+		moves := sv.moves(current.s)
+		sv.expanded++
+		sv.branches += len(moves)
+		for _, move := range moves {
+			next, cost := sv.advance(current.s, move)
+			nextKey := next.String()
+			g := current.g + cost
+			if d, ok := dist[nextKey]; ok && d <= g {
+				continue
+			}
+			dist[nextKey] = g
+			parents[nextKey] = parent{current.key, move}
+
+			// If this move completes a path, record it rather than queueing it, since there's nowhere further to go.
+			if next.keys.containsAll(sv.goal) {
+				d, ok := sv.finish(next)
+				if ok && (sv.best == 0 || g+d < sv.best) {
+					sv.best, bestKey = g+d, nextKey
+				}
+				continue
+			}
+			if bound := sv.lowerBound(next); bound != noPath {
+				heap.Push(q, node{s: next, key: nextKey, g: g, bound: g + bound})
+			}
+		}
+	}
+	if sv.best == 0 && !s.keys.containsAll(sv.goal) {
+		return 0, fmt.Errorf("%w: its keys can't all be collected", errUnsolvable)
+	}
+	sv.steps = sv.pathTo(bestKey, parents)
+	if sv.returnHome && bestKey != "" {
+		var last state
+		last.cells = make([]*cell, len(s.cells))
+		copy(last.cells, s.cells)
+		for _, move := range sv.steps {
+			last.cells[move.robot] = move.path.dest
+		}
+		last.keys = sv.goal
+		sv.steps = append(sv.steps, sv.returnSteps(last)...)
+	}
+	return sv.best, nil
+}
+
+// pathTo returns the moves which make up the shortest known path to the state with the given key, by following parents back to the initial state.
+func (sv *solver) pathTo(key string, parents map[string]parent) []step {
+	var steps []step
+	for p, ok := parents[key]; ok; p, ok = parents[p.key] {
+		steps = append(steps, p.move)
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return steps
+}
+
+// lowerBound returns a lower bound on the length of the shortest path from s to the end state: the greatest distance from any uncollected key
+// to the nearest robot, ignoring doors, since some robot must walk at least that far to collect it. A robot which could be recalled is as near
+// to a key as its start cell is, plus the cost of the recall. If there is an uncollected key which no robot can reach, it returns noPath.
+func (sv *solver) lowerBound(s state) int {
+	var bound int
+	for _, char := range (sv.goal &^ s.keys).chars() {
+		nearest := noPath
+		for i, c := range s.cells {
+			if d := sv.distance(c, char); d != noPath && (nearest == noPath || d < nearest) {
+				nearest = d
+			}
+			if sv.recall == 0 || c == sv.starts[i] {
+				continue
+			}
+			if d := sv.distance(sv.starts[i], char); d != noPath && (nearest == noPath || sv.recall+d < nearest) {
+				nearest = sv.recall + d
+			}
+		}
+		if nearest == noPath {
+			return noPath
+		}
+		if nearest > bound {
+			bound = nearest
+		}
+	}
+	return bound
+}
+
+// distance returns the length of the path from c to the key char, ignoring doors, or noPath if there is no such path.
+func (sv *solver) distance(c *cell, char byte) int {
+	if sv.distances == nil {
+		sv.distances = make(map[*cell]map[byte]int)
+	}
+	d, ok := sv.distances[c]
+	if !ok {
+		d = make(map[byte]int)
+		for _, p := range c.paths {
+			// Take the shortest if there are several paths to the key, as there may be with hidden passages, so that the bound stays admissible.
+			if n, ok := d[p.dest.char]; p.dest.cellType == key && (!ok || p.len < n) {
+				d[p.dest.char] = p.len
+			}
+		}
+		sv.distances[c] = d
+	}
+	if n, ok := d[char]; ok {
+		return n
+	}
+	return noPath
+}
+
+// segmentLength returns the length of the shortest path through m from the state from to the state to, in which the robots collect exactly
+// the keys which are in to but not in from, and finish in to's positions. The second return value is false if there is no such path,
+// including if to doesn't hold all of the keys held in from, or has a different number of robots.
+func segmentLength(m *maze, from, to state) (int, bool) {
+	if len(from.cells) != len(to.cells) || !to.keys.containsAll(from.keys) {
+		return 0, false
+	}
+	goal := to.String()
+	dist := map[string]int{from.String(): 0}
+	q := &queue{{s: from, key: from.String()}}
+	for q.Len() > 0 {
+		current := heap.Pop(q).(node)
+		if current.key == goal {
+			return current.g, true
+		}
+		if current.g > dist[current.key] {
+			continue
+		}
+		for i, c := range current.s.cells {
+			for _, p := range c.paths {
+				if !p.viable(current.s.keys) || !to.keys.contains(p.dest.char) {
+					continue
+				}
+				next := current.s.next(step{robot: i, path: p})
+				nextKey := next.String()
+				g := current.g + p.len
+				if d, ok := dist[nextKey]; ok && d <= g {
+					continue
+				}
+				dist[nextKey] = g
+				heap.Push(q, node{s: next, key: nextKey, g: g, bound: g})
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+// keyCell returns the cell in m holding the key char, failing the test if there isn't one.
+func keyCell(t *testing.T, m *maze, char byte) *cell {
+	t.Helper()
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && c.cellType == key && c.char == char {
+				return c
+			}
+		}
+	}
+	t.Fatalf("no key %c in the maze", char)
+	return nil
+}
+
+func TestSegmentLength(t *testing.T) {
+	for _, tc := range []struct {
+		example          int
+		fromAt, fromKeys string // the key each robot is at, or "@" for its start cell, and the keys held
+		toAt, toKeys     string
+		want             int
+		ok               bool
+	}{
+		{0, "@", "", "a", "a", 2, true},
+		{0, "@", "", "b", "ab", 8, true},
+		{0, "a", "a", "b", "ab", 6, true},
+		{0, "@", "", "b", "b", 0, false},   // b is behind door A, and a isn't to be collected
+		{0, "b", "ab", "a", "a", 0, false}, // the keys held can't be lost
+		{1, "@", "", "f", "abcdef", 86, true},
+		{1, "@", "", "e", "abcdef", 0, false}, // the last key collected is always f
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		at := func(char byte) *cell {
+			if char == '@' {
+				return m.start()[0]
+			}
+			return keyCell(t, m, char)
+		}
+		from := state{cells: []*cell{at(tc.fromAt[0])}, keys: keysOf(tc.fromKeys)}
+		to := state{cells: []*cell{at(tc.toAt[0])}, keys: keysOf(tc.toKeys)}
+		if got, ok := segmentLength(m, from, to); got != tc.want || ok != tc.ok {
+			t.Errorf("%s from %s holding %q to %s holding %q: got %d, %t, want %d, %t",
+				ex.name, tc.fromAt, tc.fromKeys, tc.toAt, tc.toKeys, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	// The states must have the same number of robots.
+	m := mustParse(t, parseOptions{}, examples[5].rows...)
+	if _, ok := segmentLength(m, state{cells: m.start()}, state{cells: m.start()[:1]}); ok {
+		t.Error("got a segment between states with different numbers of robots")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// runBenchmark parses and solves the maze in input the given number of times, timing each phase separately, and prints a summary to
+// standard error: the time taken by each phase and the number of states memoized by the solver, or the minimum and median times if
+// there is more than one run. It returns the answer.
+func runBenchmark(input []byte, opts parseOptions, runs int) (int, error) {
+	if runs < 1 {
+		return 0, fmt.Errorf("-runs must be at least 1, not %d", runs)
+	}
+	var parseTimes, solveTimes []time.Duration
+	var answer, states int
+	for i := 0; i < runs; i++ {
+		t0 := time.Now()
+		m, err := readMaze(bytes.NewReader(input), opts)
+		if err != nil {
+			return 0, err
+		}
+		if *region != "" {
+			r, err := parseRegion(*region)
+			if err != nil {
+				return 0, err
+			}
+			if m, err = m.crop(r); err != nil {
+				return 0, err
+			}
+		}
+		if err := m.checkPaths(); err != nil {
+			return 0, err
+		}
+		t1 := time.Now()
+		initial := state{cells: m.start(), keys: 0}
+		sv, err := configureSolver(m, initial)
+		if err != nil {
+			return 0, err
+		}
+		if answer, err = sv.solve(context.Background(), initial); err != nil {
+			return 0, err
+		}
+		parseTimes = append(parseTimes, t1.Sub(t0))
+		solveTimes = append(solveTimes, time.Since(t1))
+		states = sv.states()
+	}
+	if runs == 1 {
+		fmt.Fprintf(os.Stderr, "parse: %s solve: %s states: %d\n", millis(parseTimes[0]), millis(solveTimes[0]), states)
+		return answer, nil
+	}
+	parseMin, parseMedian := minMedian(parseTimes)
+	solveMin, solveMedian := minMedian(solveTimes)
+	fmt.Fprintf(os.Stderr, "runs: %d parse: min %s median %s solve: min %s median %s states: %d\n",
+		runs, millis(parseMin), millis(parseMedian), millis(solveMin), millis(solveMedian), states)
+	return answer, nil
+}
+
+// minMedian returns the minimum and median of times, which must not be empty. times is sorted in place.
+func minMedian(times []time.Duration) (time.Duration, time.Duration) {
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	n := len(times)
+	if n%2 == 0 {
+		return times[0], (times[n/2-1] + times[n/2]) / 2
+	}
+	return times[0], times[n/2]
+}
+
+// millis formats d as a number of milliseconds, such as "12.34ms".
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+}
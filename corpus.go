@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runCorpus solves each maze in dir with the extension .txt, parsing it according to opts, and compares its answer to the answer in the file
+// with the same name and the extension .expected. It prints a line for each maze and a summary, and returns an error if any maze failed.
+// Each maze is subject to -timeout, if it is set.
+func runCorpus(dir string, opts parseOptions) error {
+	names, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, name := range names {
+		if err := checkCorpusMaze(name, opts); err != nil {
+			fmt.Printf("FAIL %s: %v\n", filepath.Base(name), err)
+			failed++
+			continue
+		}
+		fmt.Printf("PASS %s\n", filepath.Base(name))
+	}
+	fmt.Printf("%d passed, %d failed\n", len(names)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d mazes failed", failed, len(names))
+	}
+	return nil
+}
+
+// checkCorpusMaze solves the maze in the file with the given name and returns an error if its answer differs from the expected answer.
+func checkCorpusMaze(name string, opts parseOptions) error {
+	b, err := os.ReadFile(strings.TrimSuffix(name, ".txt") + ".expected")
+	if err != nil {
+		return err
+	}
+	want, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("invalid expected answer: %w", err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := readMaze(f, opts)
+	if err != nil {
+		return err
+	}
+	if err := m.checkPaths(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	initial := state{cells: m.start(), keys: 0}
+	sv, err := configureSolver(m, initial)
+	if err != nil {
+		return err
+	}
+	got, err := sv.solve(ctx, initial)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("got %d, want %d", got, want)
+	}
+	return nil
+}
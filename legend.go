@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// decodeLegend decodes a maze in the legend format into the equivalent rows of characters. The input begins with a legend, which is a
+// block of lines of the form "chars role", where role is one of "wall", "floor", "start", "key" or "door", and chars lists the characters
+// which play that role. The legend ends at the first empty line, and the rest of the input is the grid. Keys and doors are paired by
+// position: the nth character listed for the role key opens the nth character listed for the role door, counting across all lines for
+// each role in order. For example, the legend "$% key" and "*+ door" means that $ opens * and % opens +.
+func decodeLegend(rows []string) ([]string, error) {
+	roles := make(map[rune]byte)
+	var keys, doors []rune
+	n := 0
+	for ; n < len(rows) && rows[n] != ""; n++ {
+		chars, role, ok := strings.Cut(rows[n], " ")
+		if !ok || chars == "" {
+			return nil, fmt.Errorf("legend line %d: want chars role", n+1)
+		}
+		for _, r := range chars {
+			if _, ok := roles[r]; ok {
+				return nil, fmt.Errorf("legend line %d: %q has more than one role", n+1, r)
+			}
+			switch role {
+			case "wall":
+				roles[r] = '#'
+			case "floor":
+				roles[r] = '.'
+			case "start":
+				roles[r] = '@'
+			case "key":
+				roles[r] = byte('a' + len(keys))
+				keys = append(keys, r)
+			case "door":
+				roles[r] = byte('A' + len(doors))
+				doors = append(doors, r)
+			default:
+				return nil, fmt.Errorf("legend line %d: unknown role %q", n+1, role)
+			}
+		}
+	}
+	if len(keys) > 26 || len(doors) > 26 {
+		return nil, fmt.Errorf("legend has %d keys and %d doors; at most 26 of each are supported", len(keys), len(doors))
+	}
+	grid := make([]string, 0, len(rows)-n)
+	for i := n + 1; i < len(rows); i++ {
+		row := make([]byte, 0, len(rows[i]))
+		for _, r := range rows[i] {
+			char, ok := roles[r]
+			if !ok {
+				return nil, fmt.Errorf("row %d: %q is not in the legend", i-n, r)
+			}
+			row = append(row, char)
+		}
+		grid = append(grid, string(row))
+	}
+	return grid, nil
+}
@@ -3,267 +3,3688 @@ day18 is a solution to the day 18 puzzle from Advent of Code 2019 - see https://
 
 It reads an ASCII maze and prints the shortest path which collects all of the keys in the maze (represented by lower-case characters).
 
-If arguments are provided, the first argument is assumed to be the path of the input file. Otherwise, input is read from standard input.
+If arguments are provided, the first argument is assumed to be the path of the input file, or "-" for standard input. Otherwise, input is
+read from standard input, unless the maze is given by the -inline flag. Input read from standard input is decompressed if it is gzipped.
+
+If the program is interrupted or times out while solving, it prints the length of the shortest path found so far (which may not be optimal) before exiting.
+
+The exit code is 0 if the maze was solved, 2 if the input is not a valid maze, 3 if the keys in the maze can't all be collected,
+130 if the program was interrupted, and 1 for any other error. Errors are written to standard error, and nothing is written to standard output.
+
+Any non-ASCII symbol in the maze, such as '█' or an emoji, is treated as a wall, so walls may be drawn with multi-byte glyphs. Keys are limited
+to the characters a-z, and any other non-ASCII character, such as 'é', is an error.
+
+The following flags are supported:
+
+	-trace
+		print each move of the shortest path, followed by the number of steps walked by each robot and the doors opened in order, and
+		then a line for each door opened, such as "door A: 2", giving the total number of steps walked by all of the robots when its key
+		is collected - the earliest point along the route at which it can be opened
+	-trace-bits
+		with -trace, follow each move with the keys collected so far as a hexadecimal bitmap, in which bit 0 is the key a, for example
+		"(keys 0x5)" once a and c have been collected. The memo table written by -dump-table shows the same bitmap in decimal.
+	-trace-json
+		instead of the plain answer, print a JSON object describing the shortest path, for use by animation tools - for example:
+		{"width":9,"height":3,"answer":8,"steps":[{"robot":1,"key":"a","length":2,"total":2},{"robot":1,"key":"b","length":6,"total":8}]}
+		Robots are numbered from 1, and a step which returns a robot to its start (see -return) has the key "@".
+	-binary
+		instead of the plain answer, write exactly 16 bytes to standard output: the answer as a little-endian signed 64-bit integer
+		(bytes 0-7), followed by the number of keys collected along the shortest path in the same form (bytes 8-15). Nothing else is
+		written to standard output, and errors are reported on standard error and by the exit code as usual. -answer-only takes precedence.
+	-solver algorithm
+		the algorithm used to find the shortest path: "memo" (the default) is a recursive search which memoizes the distance from each state
+		to the end, and "astar" searches the states in order of a lower bound on the length of the complete path through each. The astar
+		solver stops as soon as the best complete path it has found is proven optimal, and if it times out (see -timeout) or is
+		interrupted, it reports the best path found so far, which is labelled as not proven optimal.
+	-dump-table file
+		after solving, write each memoized state and its distance from the end state to the given file, one per line in the form
+		"key distance", sorted by key. The key lists the character under each robot followed by the collected keys as a bitmap.
+	-stats
+		after solving, print the number of states explored by the solver and the average number of moves available from each (the
+		branching factor) to standard error, along with the largest number of states held by the memo table at any one time and the
+		number of distinct cells visited along the shortest path, in the form "states: 890 branching factor: 3.21 peak table: 1024
+		route cells: 75"
+	-stats-histogram
+		after solving, print a line of the form "distance count" to standard error for each distance from the end state memoized by the
+		solver, in ascending order, giving the number of states at that distance. States from which the keys can't all be collected are
+		counted under the distance -1. Only the memo solver memoizes distances, so nothing is printed with -solver=astar.
+	-tree file
+		after solving, write the moves explored by the solver to the given file as a DOT graph, in which each node is a state (labelled
+		as in -dump-table) and each edge is labelled with the key collected and the length of the move. Edges to states whose distance
+		had already been memoized are dashed. Only moves from states in which fewer than -tree-depth keys (3 by default) have been
+		collected are included, to keep the graph readable.
+	-tree-depth n
+		with -tree, only include moves from states in which fewer than n keys have been collected
+	-inline maze
+		read the maze from the flag's value instead of a file or standard input, with rows separated by the two characters \n - for
+		example -inline='###\n#@a\n###'
+	-base64
+		decode the input from base64 before parsing it, whether it comes from a file, standard input or -inline. Either the standard or
+		the URL-safe alphabet may be used, padding is optional and whitespace is ignored. If the decoded input is gzipped, it is then
+		decompressed, so a maze can be passed as the output of gzip | base64.
+	-deterministic-map
+		explore the moves from each state in order of the key they collect, so that the order in which the search visits states (and
+		anything derived from it, such as the path found so far when interrupted) is reproducible from one version of the maze to the next
+	-goals list
+		instead of collecting all of the keys, find the shortest path which collects each of the given sets of keys, which are separated
+		by semicolons, independently from the start - for example -goals='ab;cd' prints lines of the form "ab: 12" and "cd: 30". Other keys
+		may be collected along the way if they open doors. A set of keys which can't be collected is reported as unsolvable.
+	-seed n
+		if n is not 0, explore the moves from each state in a random order generated from the seed n, and break ties between equally
+		short routes in the same order, so that different seeds can give different traces of equally optimal routes. The answer doesn't
+		depend on the seed, and the same seed always gives the same trace.
+	-first
+		instead of searching for the shortest route, print the length of the first route found by a depth-first search which always
+		collects the nearest reachable key first, backtracking only from dead ends, followed by the keys in the order they are collected,
+		in the form "136 steps: abcd". This is much faster than a full search on large mazes, and gives an upper bound on the answer.
+	-regret
+		after the answer, print the length of the route found by -first, the difference between it and the answer (the regret of
+		collecting the nearest key first) and their ratio, in the form "greedy: 114 optimal: 86 regret: 28 (1.33x)". A high regret
+		marks a maze which traps naive strategies.
+	-budget n
+		instead of collecting all of the keys, find the route which collects the most keys within n steps, and print the number of keys,
+		the number of steps walked and the keys in the order they are collected. Ties are broken in favour of the shorter route.
+	-frames dir
+		write a frame to the given directory for each position along the shortest path, in which each robot moves one cell per frame.
+		The frames are named frame00000.txt, frame00001.txt and so on, and show the robots as '@' and collected keys and opened doors as '.'.
+	-overlay
+		after the answer, print the maze with the route of the shortest path drawn over it: empty cells passed through once are shown
+		as '+', and cells passed through more than once, where the route doubles back or crosses itself, are shown as '*'
+	-tui
+		instead of printing the answer, step through the shortest path on the terminal, redrawing the maze after each move as in -frames
+		along with the keys collected and the steps walked so far. Press enter to advance one move, or type q and press enter to quit.
+	-ppm
+		with -frames, write each frame as a PPM image with the extension .ppm, in which each cell is drawn as a block of colour
+	-out file
+		write everything which would be printed to standard output (the answer, and anything else requested, such as -trace, -overlay or
+		-trace-json) to the given file instead, creating or truncating it. If the file is -, standard output is used as usual. Errors
+		are still written to standard error.
+	-sep
+		print the answer with commas separating groups of thousands
+	-hex
+		print the answer in hexadecimal
+	-oneway
+		treat the characters '>', '<', '^' and 'v' as one-way passages, which can only be exited in the direction they point
+	-reveal list
+		a semicolon-separated list of hidden passages of the form key:r1,c1:r2,c2, each of which joins the open cells at row r1 and column
+		c1 and at row r2 and column c2 (counting from 0), but can only be followed once the given key has been collected - for example
+		-reveal='a:1,7:3,7'. The cells need not be neighbours. This applies to every format except gob and portals.
+	-slashes
+		parse '/' and '\' as diagonal passages, each of which is only joined to the two cells at either end of the slash: the cells to the
+		north-east and south-west of '/', and to the north-west and south-east of '\'. Other cells are joined to a diagonal neighbour only
+		if it is a slash whose end points at them.
+	-keys-need-doors
+		treat lower-case characters with no matching door as plain floor rather than keys
+	-explain
+		describe each move of the shortest path in words, including any doors opened along the way
+	-partition
+		after the answer, print the keys collected by each robot along the shortest path, one line per robot in the form "robot 1: abc",
+		which shows how the robots divide the keys between them
+	-explain-unsolvable
+		if the keys can't all be collected, describe the specific obstruction where possible, such as a key which no start cell can
+		reach, or a cycle of keys each of which is behind the door of the next - for example "key q is behind door M, but key m is
+		behind door Q (cyclic dependency)"
+	-delimiter string
+		treat the input as a stream of mazes separated by lines equal to the given string, printing the answer to each maze as soon as it has been read.
+		Each maze must be completely written before its delimiter (or the end of the input), but may arrive in any number of writes, so
+		the input may be a named pipe. A maze which cannot be parsed or solved is reported on standard error, prefixed with its number,
+		and the rest are still solved; the exit code is then that of the first maze which failed.
+	-region r1,c1,r2,c2
+		treat every cell outside the rectangle from row r1 and column c1 to row r2 and column c2 (counting from 0, inclusive) as a wall,
+		and only collect the keys within it. The rectangle must contain at least one start cell. Doors whose keys lie outside the
+		rectangle can't be opened.
+	-both
+		print the answers to both parts of the puzzle, in the form "part 1: 132" and "part 2: 72". The part 2 answer is found by replacing
+		the single start cell and its eight neighbours (which must be empty) with walls and four start cells, one at each corner, and
+		solving the resulting maze with four robots.
+	-diff file
+		instead of solving the maze, print the position of each cell which differs from the maze in the given file, which must have the
+		same dimensions, as a line of the form "r,c: x -> y", where x and y are the characters of the cell in each maze
+	-edges
+		print each edge between adjacent cells as a line of the form "r1,c1 - r2,c2" instead of solving the maze, using "->" for one-way edges
+	-order string
+		the order in which the solver explores the moves available from each state: "index" (the default) explores each robot's moves in turn,
+		"nearest" explores the shortest moves first, and "far" explores the longest moves first. The answer does not depend on the order.
+	-list-keys
+		print the keys in the maze in alphabetical order, one per line, instead of solving it
+	-comma
+		with -list-keys, print the keys on a single line separated by commas
+	-diameter
+		print the greatest distance between any pair of keys, ignoring doors, instead of solving the maze
+	-difficulty
+		print a rough estimate of how hard the maze is to solve, without solving it - see maze.Difficulty for the formula
+	-return
+		require each robot to return to its start cell once all of the keys have been collected, adding the distance walked to the answer
+	-shuffle
+		also solve a copy of the maze which has been transposed, mirrored or both, chosen at random, and fail if its answer differs
+	-answer-only
+		print nothing but the answer as a plain decimal integer, overriding any flags which would print anything else, such as -trace,
+		-overlay or -binary. It cannot be used with the modes which print something other than the answer, such as -edges or -budget.
+	-format format
+		the format of the input: "text" (the default) is a grid of characters, and "rle" is a grid in which each row is run-length encoded,
+		so that each character may be preceded by a count of the number of times it is repeated - for example "10#" represents ten walls.
+		"tokens" is a grid in which each row is a list of whitespace-separated tokens: "wall", "floor", "start", "key_a" or "door_A".
+		"portals" reads a maze in the style of the day 20 puzzle, in which pairs of upper-case letters label portals which join the open
+		cells next to them, and prints the length of the shortest path from AA to ZZ. "gob" reads a maze written by -save. "legend" reads
+		a grid preceded by a legend which maps characters to roles, such as "#X wall", ". floor", "S start", "$% key" and "*+ door", ending
+		with an empty line. The nth character listed as a key opens the nth character listed as a door, and they are reported as the
+		nth letters of the alphabet, such as a and A for the first key and door.
+	-transpose
+		read each line of the input as a column of the maze rather than a row, for mazes written in column-major order. This applies to
+		every format except gob, after any run-length encoding or tokens have been decoded.
+	-connectivity mode
+		which cells are neighbours: "4" (the default) joins each cell to the cells to its north, west, south and east, "8" joins it
+		to the diagonal cells as well, and "hex" reads the grid as hexagonal cells with each odd row shifted half a cell to the right,
+		so that each cell is joined to two cells in each of the rows above and below it as well as to the cells to its west and east
+	-max-keys n
+		reject mazes containing more than n distinct keys, before finding the paths between them. The default is 26, the most that the
+		solver supports.
+	-maxwidth n, -maxheight n, -maxcells n
+		reject mazes more than n cells wide, more than n cells high, or with more than n cells in total (including walls), with the error
+		"maze exceeds size limits". Input in every format but gob is checked as each row is read, so an oversized maze is rejected
+		without reading all of it, which protects -serve from memory exhaustion; the size of a portal maze includes its labels. Gob
+		mazes are checked once they have been loaded. The default of 0 means no limit.
+	-save file
+		instead of solving the maze, write it to the given file in gob format, including the paths between its keys, so that it can be
+		read again with -format=gob without being parsed
+	-robots n
+		fail unless the maze contains exactly n start cells, one for each robot
+	-fuel n
+		give each robot n units of fuel, which it uses up at one unit per step and which is refilled to n whenever it collects a key. A
+		robot which would run out of fuel before reaching the next key can't make that move, and with -return, a robot must have enough
+		fuel left to get back to its start cell. Since every move ends by collecting a key, each robot has a full tank at the start of
+		every move, so this is the same as limiting each move to n steps. A recall (see -recall) uses no fuel. The default of 0 means
+		unlimited fuel.
+	-recall n
+		allow any robot to be recalled to its start cell at a cost of n steps, instead of walking there, at any point. A recall is shown
+		by -trace as "robot 1: recalled to start for 5". The default of 0 disables recalls.
+	-range n
+		limit each robot to walking n steps between recharges, which it gets by returning to its start cell. A move to a key more than n
+		steps away must go via the robot's start cell, and is only possible if both legs are at most n steps. The moves printed by -trace
+		include the steps walked via the start cell, but -frames and -overlay show the direct route.
+	-door-cost n
+		add n to the answer for each door opened, the first time it is passed through by any robot, as though opening it took n extra
+		steps. The lengths of the moves printed by -trace and -explain are the steps walked, and don't include the cost of opening doors.
+	-assign list
+		a semicolon-separated list of pairs of the form robot:keys, such as 1:a-f;2:g-l,z, each of which means that the robot can only
+		collect the given keys, which are listed as keys or ranges of keys separated by commas. Robots which aren't listed can collect
+		any key. Note that the list must be quoted in most shells.
+	-optional keys
+		keys which need not be collected for the maze to be solved, such as -optional=xyz, but which the robots may still collect if
+		opening their doors gives a shorter route. Every other key must be collected as usual.
+	-collect-order list
+		a comma-separated list of constraints of the form a<b, such as a<b,c<b, each of which means that the key a must be collected
+		before the key b. The answer is the shortest route which respects every constraint. Constraints which form a cycle make the
+		maze unsolvable.
+	-consume-keys
+		opening a door uses up its key, so that each door can only be passed through once: a robot which has been through a door can't
+		come back the same way, and no other robot can follow it. Keys still count as collected once they have been used. -trace-bits
+		and -dump-table show the keys collected, including those which have been used up.
+	-anti-keys list
+		a comma-separated list of pairs of the form anti:removed, such as q:a,q:b, each of which means that collecting the key anti
+		removes the key removed from the keys held, so that it must be collected again, and its doors can't be passed through until it
+		is. A robot standing on the removed key can pick it up again without moving, which -trace shows as "picked up key a again". A
+		removed key must not itself be an anti-key.
+	-activation list
+		a comma-separated list of pairs of the form robot:key, such as 1:q,3:m, each of which means that the robot starts inactive and
+		can't move until the key has been collected by another robot. Robots are numbered from 1 in the order of their start cells.
+	-cache-segments
+		cache the moves available to each robot for each combination of its position and the keys collected, rather than working them out
+		each time. This only helps when there are several robots, and is usually slower even then.
+	-compress-state
+		memoize each state using a fixed-size comparable struct rather than a string, which avoids formatting a string for every state
+		visited. Mazes with more than 8 robots are not supported.
+	-timeout duration
+		give up if a maze has not been solved within the given duration. If the flag is not given, the timeout is read from the
+		environment variable DAY18_TIMEOUT, if it is set, in the same format - for example DAY18_TIMEOUT=30s.
+	-timeout-partial
+		if the timeout expires after a complete path has been found, print the length of the shortest path found so far as the answer and
+		exit with code 0, rather than failing. A note that the answer has not been proven optimal is written to standard error. If no
+		complete path has been found, the program still fails.
+	-benchmark
+		parse and solve the maze, timing each separately, and print a summary of the form "parse: 1.23ms solve: 45.67ms states: 890"
+		to standard error, where states is the number of states memoized by the solver. The answer is still printed to standard output.
+	-runs n
+		with -benchmark, parse and solve the maze n times, and print the minimum and median time taken by each instead
+	-corpus dir
+		instead of reading a maze, solve each file in the given directory with the extension .txt and compare its answer to the answer in
+		the file with the same name and the extension .expected, printing PASS or FAIL for each maze followed by the number of mazes which
+		passed and failed. The exit code is 1 if any maze failed. Each maze is subject to -timeout.
+	-serve address
+		instead of reading a maze, start an HTTP server listening on the given address. Mazes sent in the body of a POST request to /solve
+		are solved and the result is returned as a JSON object of the form {"steps": 136, "keys": "afbjgnhdloepcikm"}, where keys lists
+		the keys in the order they are collected. Each request is subject to -timeout, or to a timeout of 10s if -timeout is not set.
+		Request bodies larger than 16MiB are rejected with status 413.
 */
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"math"
+	"math/bits"
+	"math/rand"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	showTrace     = flag.Bool("trace", false, "print each move of the shortest path")
+	sep           = flag.Bool("sep", false, "print the answer with thousands separators")
+	hex           = flag.Bool("hex", false, "print the answer in hexadecimal")
+	oneWay        = flag.Bool("oneway", false, "parse '>', '<', '^' and 'v' as one-way passages")
+	needDoors     = flag.Bool("keys-need-doors", false, "treat keys with no matching door as plain floor")
+	explain       = flag.Bool("explain", false, "describe each move of the shortest path in words")
+	partition     = flag.Bool("partition", false, "print the keys collected by each robot along the shortest path")
+	edges         = flag.Bool("edges", false, "print the edges between adjacent cells instead of solving the maze")
+	listKeys      = flag.Bool("list-keys", false, "print the keys in the maze instead of solving it")
+	comma         = flag.Bool("comma", false, "with -list-keys, print the keys on one line separated by commas")
+	diameter      = flag.Bool("diameter", false, "print the greatest distance between any two keys instead of solving the maze")
+	difficulty    = flag.Bool("difficulty", false, "print an estimate of how hard the maze is instead of solving it")
+	returnHome    = flag.Bool("return", false, "require each robot to return to its start cell after all keys are collected")
+	cacheSegments = flag.Bool("cache-segments", false, "cache the viable moves for each robot position and keyset")
+	solverName    = flag.String("solver", "memo", "the `algorithm` used to find the shortest path: memo or astar")
+	connectivity  = flag.String("connectivity", "4", "which cells are neighbours: `4`, 8 or hex")
+	corpus        = flag.String("corpus", "", "solve each maze in `dir` and compare its answer to the expected answer")
+	activation    = flag.String("activation", "", "a comma-separated list of `robot:key` pairs, each naming a key which activates a robot")
+	benchmark     = flag.Bool("benchmark", false, "time parsing and solving separately, and print a summary to standard error")
+	runs          = flag.Int("runs", 1, "with -benchmark, the number of times to parse and solve the maze")
+	compressState = flag.Bool("compress-state", false, "memoize states using a fixed-size key rather than a string")
+	doorCost      = flag.Int("door-cost", 0, "the number of extra steps it costs to open each door")
+	tree          = flag.String("tree", "", "after solving, write the search tree to `file` as a DOT graph")
+	treeDepth     = flag.Int("tree-depth", 3, "with -tree, the number of keys collected beyond which moves aren't recorded")
+	optional      = flag.String("optional", "", "`keys` which need not be collected, but may be if they open useful doors")
+	collectOrder  = flag.String("collect-order", "", "a comma-separated list of `a<b` constraints, each requiring the key a to be collected before b")
+	consumeKeys   = flag.Bool("consume-keys", false, "use up each key when its door is opened, so that each door can only be passed through once")
+	antiKeys      = flag.String("anti-keys", "", "a comma-separated list of `anti:removed` pairs, each naming a key whose collection removes another")
+	diff          = flag.String("diff", "", "print the cells which differ between the maze and the maze in `file`")
+	region        = flag.String("region", "", "solve only the cells within the rectangle `r1,c1,r2,c2`, treating the rest as walls")
+	stats         = flag.Bool("stats", false, "after solving, print statistics about the search to standard error")
+	assign        = flag.String("assign", "", "a semicolon-separated list of `robot:keys` pairs, each restricting a robot to the given keys")
+	tui           = flag.Bool("tui", false, "step through the shortest path interactively on the terminal")
+	overlay       = flag.Bool("overlay", false, "after the answer, print the maze with the shortest path drawn over it")
+	partial       = flag.Bool("timeout-partial", false, "on timeout, print the best answer found so far and exit successfully")
+	swapAxes      = flag.Bool("transpose", false, "read each line of the input as a column of the maze rather than a row")
+	histogram     = flag.Bool("stats-histogram", false, "after solving, print the number of memoized states at each distance to standard error")
+	fuel          = flag.Int("fuel", 0, "the fuel each robot has, which is used up one unit per step and refilled at each key, or 0 for unlimited")
+	recall        = flag.Int("recall", 0, "the cost of instantly returning a robot to its start cell, or 0 if robots can't be recalled")
+	maxRange      = flag.Int("range", 0, "the most steps a robot can walk between recharges at its start cell, or 0 for no limit")
+	both          = flag.Bool("both", false, "print the answers to both parts of the puzzle, splitting the maze into four for part 2")
+	diagnose      = flag.Bool("explain-unsolvable", false, "if the maze can't be solved, describe the obstruction")
+	goals         = flag.String("goals", "", "a semicolon-separated list of `keys` to collect, each solved independently")
+	out           = flag.String("out", "", "write the output to `file` instead of standard output, or to standard output if file is -")
+	slashes       = flag.Bool("slashes", false, "parse '/' and '\\' as diagonal passages")
+	seed          = flag.Int64("seed", 0, "if not 0, shuffle the order of moves with this seed, so that ties between optimal routes are broken at random")
+	reveals       = flag.String("reveal", "", "a semicolon-separated list of hidden passages of the form `key:r1,c1:r2,c2`")
+	dumpTable     = flag.String("dump-table", "", "after solving, write the memoized distance from each state to `file`")
+	fromBase64    = flag.Bool("base64", false, "decode the input from base64, then decompress it if it is gzipped")
+	inline        = flag.String("inline", "", "read the maze from `maze`, in which \\n separates rows, instead of a file")
+	deterministic = flag.Bool("deterministic-map", false, "explore moves in a fixed order, sorted by key")
+	regret        = flag.Bool("regret", false, "compare the answer with the length of the route found by -first")
+	first         = flag.Bool("first", false, "print the first complete route found by a greedy search, which isn't necessarily the shortest")
+	budget        = flag.Int("budget", -1, "collect as many keys as possible within `n` steps, rather than collecting all of them")
+	frames        = flag.String("frames", "", "write a frame for each step of the shortest path to `dir`")
+	ppm           = flag.Bool("ppm", false, "with -frames, write PPM images rather than text")
+	traceBits     = flag.Bool("trace-bits", false, "with -trace, show the keys collected after each move as a hexadecimal bitmap")
+	binaryOut     = flag.Bool("binary", false, "print the answer and the number of keys collected as 16 bytes of binary")
+	traceJSON     = flag.Bool("trace-json", false, "print the answer and each move of the shortest path as JSON")
+	shuffle       = flag.Bool("shuffle", false, "check that a randomly transposed or mirrored copy of the maze has the same answer")
+	answerOnly    = flag.Bool("answer-only", false, "print nothing but the answer")
+	format        = flag.String("format", "text", "the input `format`: text, rle, tokens, legend, portals or gob")
+	maxWidth      = flag.Int("maxwidth", 0, "reject mazes more than `n` cells wide, or 0 for no limit")
+	maxHeight     = flag.Int("maxheight", 0, "reject mazes more than `n` cells high, or 0 for no limit")
+	maxCells      = flag.Int("maxcells", 0, "reject mazes with more than `n` cells in total, including walls, or 0 for no limit")
+	maxKeys       = flag.Int("max-keys", 26, "reject mazes with more than `n` distinct keys")
+	save          = flag.String("save", "", "write the parsed maze to `file` in gob format instead of solving it")
+	robots        = flag.Int("robots", 0, "the expected number of robots (start cells), or 0 to accept any number")
+	timeout       = flag.Duration("timeout", 0, "give up if the maze has not been solved within this `duration`")
+	serve         = flag.String("serve", "", "start an HTTP server on `address` instead of reading a maze")
+	delimiter     = flag.String("delimiter", "", "solve each maze in a stream of mazes separated by lines equal to `sentinel`")
+)
+
+// order is the search order set by the -order flag.
+var order searchOrder
+
+func init() {
+	flag.Var(&order, "order", "the order in which to explore moves: index, nearest or far")
+}
+
+func main() {
+	if env := os.Getenv("DAY18_TIMEOUT"); env != "" {
+		d, err := time.ParseDuration(env)
+		if err != nil {
+			exit(exitError, fmt.Errorf("invalid DAY18_TIMEOUT: %w", err))
+		}
+		*timeout = d
+	}
+	flag.Parse()
+	if *out != "" && *out != "-" {
+		// Everything else writes to os.Stdout, so replacing it redirects all of the program's output to the file.
+		f, err := os.Create(*out)
+		if err != nil {
+			exit(exitError, fmt.Errorf("cannot create output file: %w", err))
+		}
+		defer f.Close()
+		os.Stdout = f
+	}
+	if *sep && *hex {
+		exit(exitError, errors.New("-sep and -hex cannot be used together"))
+	}
+	if *solverName != "memo" && *solverName != "astar" {
+		exit(exitError, fmt.Errorf("unknown solver %q", *solverName))
+	}
+	if *answerOnly {
+		// These modes print something other than the answer, so they would print nothing at all under -answer-only.
+		for _, mode := range []struct {
+			name string
+			set  bool
+		}{
+			{"-edges", *edges}, {"-list-keys", *listKeys}, {"-diameter", *diameter}, {"-difficulty", *difficulty}, {"-save", *save != ""},
+			{"-goals", *goals != ""}, {"-budget", *budget >= 0}, {"-first", *first}, {"-both", *both}, {"-diff", *diff != ""},
+			{"-tui", *tui}, {"-corpus", *corpus != ""}, {"-serve", *serve != ""},
+		} {
+			if mode.set {
+				exit(exitError, fmt.Errorf("-answer-only cannot be used with %s", mode.name))
+			}
+		}
+	}
+	opts := parseOptions{format: *format, oneWay: *oneWay, keysNeedDoors: *needDoors, maxKeys: *maxKeys, connectivity: *connectivity, transpose: *swapAxes, slashes: *slashes, reveals: *reveals,
+		maxWidth: *maxWidth, maxHeight: *maxHeight, maxCells: *maxCells}
+	if *serve != "" {
+		exit(exitError, listenAndServe(*serve, opts))
+	}
+	if *corpus != "" {
+		exit(exitOK, runCorpus(*corpus, opts))
+	}
+	var r io.Reader = os.Stdin
+	switch {
+	case *inline != "" && flag.NArg() > 0:
+		exit(exitError, errors.New("cannot read from both -inline and an input file"))
+	case *inline != "":
+		r = strings.NewReader(strings.ReplaceAll(*inline, `\n`, "\n"))
+	case flag.NArg() > 0 && flag.Arg(0) != "-":
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			exit(exitError, err)
+		}
+		defer f.Close()
+		r = f
+	default:
+		stdin, err := gunzipIfCompressed(os.Stdin)
+		if err != nil {
+			exit(exitError, err)
+		}
+		r = stdin
+	}
+	if *fromBase64 {
+		decoded, err := decodeBase64(r)
+		if err != nil {
+			exit(exitError, err)
+		}
+		r = decoded
+	}
+	if *benchmark {
+		input, err := io.ReadAll(r)
+		if err != nil {
+			exit(exitError, err)
+		}
+		answer, err := runBenchmark(input, opts, *runs)
+		if err != nil {
+			exit(exitError, err)
+		}
+		fmt.Println(formatAnswer(answer))
+		return
+	}
+	if *delimiter != "" {
+		// A maze which can't be parsed or solved doesn't stop the rest from being solved, but the program exits with the code for the first failure.
+		var n, code int
+		err := readMazes(r, *delimiter, func(rows []string) {
+			n++
+			m, err := parseMaze(rows, opts)
+			if err != nil {
+				err = withCode(exitParseError, err)
+			} else {
+				err = run(m)
+			}
+			if err == nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "maze %d: %v\n", n, err)
+			if exitCode(err) == exitInterrupted {
+				os.Exit(exitInterrupted)
+			}
+			if code == exitOK {
+				code = exitCode(err)
+			}
+		})
+		if err != nil {
+			exit(exitError, err)
+		}
+		os.Exit(code)
+	}
+	m, err := readMaze(r, opts)
+	if err != nil {
+		exit(exitParseError, err)
+	}
+	if *both {
+		exit(exitOK, solveBoth(m, opts))
+	}
+	if *diff != "" {
+		other, err := readMazeFile(*diff, opts)
+		if err != nil {
+			exit(exitParseError, err)
+		}
+		exit(exitOK, printDiff(os.Stdout, m, other))
+	}
+	if err := run(m); err != nil {
+		exit(exitCode(err), err)
+	}
+}
+
+// solveBoth solves m, which must have a single start cell, and the maze given by splitting it into four quadrants as in part 2 of the puzzle,
+// and prints both answers. The options used to parse m are used to parse the split maze, apart from its format, so m must have been read
+// from a grid of characters: portal and gob mazes, whose adjacency isn't given by the grid, are rejected. m is cropped to -region before it is
+// split, and both mazes are checked as in run, except that -robots only applies to m. Each maze is subject to -timeout and solved with -solver.
+func solveBoth(m *maze, opts parseOptions) error {
+	if opts.format == "portals" || opts.format == "gob" {
+		return withCode(exitParseError, fmt.Errorf("-both cannot be used with -format=%s", opts.format))
+	}
+	m, err := cropRegion(m)
+	if err != nil {
+		return err
+	}
+	if err := checkMaze(m, *robots); err != nil {
+		return err
+	}
+	split, err := m.split(opts)
+	if err != nil {
+		return err
+	}
+	if err := checkMaze(split, 0); err != nil {
+		return err
+	}
+	for i, part := range []*maze{m, split} {
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		initial := state{cells: part.start(), keys: 0}
+		sv, err := configureSolver(part, initial)
+		if err != nil {
+			return err
+		}
+		result, err := solveWith(ctx, sv, initial)
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i+1, err)
+		}
+		fmt.Printf("part %d: %s\n", i+1, formatAnswer(result))
+	}
+	return nil
+}
+
+// split returns a copy of m in which the start cell and its neighbours are replaced by walls and four start cells, one in each diagonal
+// neighbour, as in part 2 of the puzzle. m must have a single start cell, whose eight neighbours are all empty. The copy is parsed from
+// text according to opts.
+func (m *maze) split(opts parseOptions) (*maze, error) {
+	starts := m.start()
+	if len(starts) != 1 {
+		return nil, fmt.Errorf("cannot split a maze with %d start cells", len(starts))
+	}
+	s := starts[0]
+	rows := make([][]byte, m.h)
+	for i := range rows {
+		rows[i] = make([]byte, m.w)
+		for j := range rows[i] {
+			rows[i][j] = m.char(i, j)
+		}
+	}
+	for di := -1; di <= 1; di++ {
+		for dj := -1; dj <= 1; dj++ {
+			i, j := s.row+di, s.col+dj
+			if i < 0 || i >= m.h || j < 0 || j >= m.w || (di != 0 || dj != 0) && (m.rows[i][j] == nil || m.rows[i][j].cellType != empty) {
+				return nil, fmt.Errorf("cannot split a maze whose start cell at %d,%d is not surrounded by empty cells", s.row, s.col)
+			}
+			rows[i][j] = '#'
+			if di != 0 && dj != 0 {
+				rows[i][j] = '@'
+			}
+		}
+	}
+	lines := make([]string, len(rows))
+	for i := range rows {
+		lines[i] = string(rows[i])
+	}
+	opts.format, opts.transpose = "text", false
+	return parseMaze(lines, opts)
+}
+
+// readMazeFile reads a maze from the file with the given name, parsing it according to opts.
+func readMazeFile(name string, opts parseOptions) (*maze, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readMaze(f, opts)
+}
+
+// Exit codes used by the program.
+const (
+	exitOK          = 0
+	exitError       = 1   // a general error, such as a missing input file or a timeout
+	exitParseError  = 2   // the input is not a valid maze
+	exitUnsolvable  = 3   // the maze is valid, but its keys can't all be collected
+	exitInterrupted = 130 // the program was interrupted while solving
 )
 
-func main() {
-	r := os.Stdin
-	if len(os.Args) > 1 {
-		f, err := os.Open(os.Args[1])
-		defer f.Close()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+// exit prints err to standard error, if it is not nil, and exits the program with the given exit code.
+// If err is not nil and code is exitOK, the program exits with exitError instead.
+func exit(code int, err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if code == exitOK {
+			code = exitError
+		}
+	}
+	os.Exit(code)
+}
+
+// codedError is an error which carries the code the program should exit with.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode returns err along with the code the program should exit with if it fails because of err, or nil if err is nil.
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// exitCode returns the code the program should exit with because of err: exitOK if err is nil, the code given to withCode if there is one,
+// and exitError otherwise.
+func exitCode(err error) int {
+	var coded *codedError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &coded):
+		return coded.code
+	}
+	return exitError
+}
+
+// saveMaze writes m to the file with the given name in gob format.
+func saveMaze(m *maze, name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := m.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// cropRegion returns a copy of m cropped to the rectangle given by -region (see maze.crop), or m itself if -region isn't set.
+func cropRegion(m *maze) (*maze, error) {
+	if *region == "" {
+		return m, nil
+	}
+	r, err := parseRegion(*region)
+	if err != nil {
+		return nil, err
+	}
+	if m, err = m.crop(r); err != nil {
+		return nil, withCode(exitParseError, err)
+	}
+	return m, nil
+}
+
+// checkMaze returns an error carrying exitParseError if m can't be solved with the given number of robots (see checkRobots), or if it
+// has paths the solver can't handle or keys which lock themselves in (see checkPaths and checkSelfLocking).
+func checkMaze(m *maze, robots int) error {
+	if err := checkRobots(len(m.start()), robots); err != nil {
+		return withCode(exitParseError, err)
+	}
+	if err := m.checkPaths(); err != nil {
+		return withCode(exitParseError, err)
+	}
+	if err := m.checkSelfLocking(); err != nil {
+		return withCode(exitParseError, err)
+	}
+	return nil
+}
+
+// solveWith returns the length of the shortest path through the maze from initial, found by sv using the algorithm named by -solver.
+func solveWith(ctx context.Context, sv *solver, initial state) (int, error) {
+	if *solverName == "astar" {
+		return sv.solveAStar(ctx, initial)
+	}
+	return sv.solve(ctx, initial)
+}
+
+// run solves m and writes the answer to standard output, along with any other output requested by the command-line flags.
+// If it fails, the error it returns carries the code the program should exit with - see withCode.
+func run(m *maze) error {
+	m, err := cropRegion(m)
+	if err != nil {
+		return err
+	}
+	if *edges {
+		printEdges(os.Stdout, m)
+		return nil
+	}
+	if *listKeys {
+		printKeys(os.Stdout, m.keys, *comma)
+		return nil
+	}
+	if *diameter {
+		fmt.Println(m.keyDiameter())
+		return nil
+	}
+	if *difficulty {
+		fmt.Printf("%.2f\n", m.Difficulty())
+		return nil
+	}
+	if *save != "" {
+		return saveMaze(m, *save)
+	}
+	if err := checkMaze(m, *robots); err != nil {
+		return err
+	}
+	initial := state{cells: m.start(), keys: 0}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	sv, err := configureSolver(m, initial)
+	if err != nil {
+		return err
+	}
+	if *tree != "" {
+		sv.treeDepth = *treeDepth
+	}
+	if *goals != "" {
+		return sv.solveGoals(ctx, initial, *goals)
+	}
+	if *budget >= 0 {
+		walked, steps := sv.collectWithin(initial, *budget)
+		var keys []byte
+		for _, st := range steps {
+			if !st.recall {
+				keys = append(keys, st.path.dest.char)
+			}
+		}
+		fmt.Printf("%d keys in %d steps: %s\n", len(keys), walked, keys)
+		if *showTrace {
+			printTrace(os.Stdout, initial, steps, *traceBits)
+		}
+		return nil
+	}
+	if *first {
+		walked, steps, ok := sv.firstSolution(initial)
+		if !ok {
+			return withCode(exitUnsolvable, errUnsolvable)
+		}
+		var keys []byte
+		for _, st := range steps {
+			if !st.recall {
+				keys = append(keys, st.path.dest.char)
+			}
+		}
+		fmt.Printf("%d steps: %s\n", walked, keys)
+		if *showTrace {
+			printTrace(os.Stdout, initial, steps, *traceBits)
+		}
+		return nil
+	}
+	result, err := solveWith(ctx, sv, initial)
+	switch {
+	case errors.Is(err, context.Canceled) && sv.best > 0:
+		return withCode(exitInterrupted, fmt.Errorf("interrupted: best found (not proven optimal) is %s steps", formatAnswer(sv.best)))
+	case errors.Is(err, context.DeadlineExceeded) && sv.best > 0 && *partial:
+		fmt.Fprintln(os.Stderr, "timed out: the answer is the best found, which has not been proven optimal")
+		fmt.Println(formatAnswer(sv.best))
+		return nil
+	case errors.Is(err, context.DeadlineExceeded) && sv.best > 0:
+		return fmt.Errorf("timed out: best found (not proven optimal) is %s steps", formatAnswer(sv.best))
+	case errors.Is(err, context.Canceled):
+		return withCode(exitInterrupted, errors.New("interrupted: no path has been found so far"))
+	case errors.Is(err, errUnsolvable):
+		if *diagnose {
+			if reason := m.explainUnsolvable(); reason != nil {
+				err = reason
+			}
+		}
+		return withCode(exitUnsolvable, err)
+	case err != nil:
+		return fmt.Errorf("no solution found: %w", err)
+	}
+	if *dumpTable != "" {
+		if err := sv.dumpTable(*dumpTable); err != nil {
+			return err
+		}
+	}
+	if *stats {
+		fmt.Fprintf(os.Stderr, "states: %d branching factor: %.2f peak table: %d route cells: %d\n",
+			sv.expanded, sv.branchingFactor(), sv.peakStates, routeCells(initial, sv.trace(initial)))
+	}
+	if *histogram {
+		sv.printHistogram(os.Stderr)
+	}
+	if *tree != "" {
+		if err := sv.writeTree(*tree); err != nil {
+			return err
+		}
+	}
+	if *shuffle {
+		name, t, pos := randomSymmetry(m)
+		// The robots are kept in their original order, as -assign and -activation refer to them by number.
+		tinitial := state{keys: initial.keys}
+		for _, c := range initial.cells {
+			i, j := pos(c.row, c.col)
+			tinitial.cells = append(tinitial.cells, t.rows[i][j])
+		}
+		tsv, err := configureSolver(t, tinitial)
+		if err != nil {
+			return err
+		}
+		tresult, err := tsv.solve(ctx, tinitial)
+		if err != nil {
+			return fmt.Errorf("solving %s maze: %w", name, err)
+		}
+		if tresult != result {
+			return fmt.Errorf("%s maze has answer %d, but the original maze has answer %d", name, tresult, result)
+		}
+	}
+	if *frames != "" {
+		if err := writeFrames(*frames, m, initial, sv.trace(initial), *ppm); err != nil {
+			return err
+		}
+	}
+	// Everything from here on writes to standard output, so -answer-only is enforced once, here.
+	if *answerOnly {
+		fmt.Println(result)
+		return nil
+	}
+	if *tui {
+		return runTUI(m, initial, sv.trace(initial))
+	}
+	if *traceJSON {
+		return writeTraceJSON(os.Stdout, m, result, sv.trace(initial))
+	}
+	if *binaryOut {
+		return writeBinaryAnswer(os.Stdout, result, sv.trace(initial))
+	}
+	fmt.Println(formatAnswer(result))
+	if *overlay {
+		for _, row := range m.overlay(initial, sv.trace(initial)) {
+			fmt.Printf("%s\n", row)
+		}
+	}
+	if *showTrace {
+		printTrace(os.Stdout, initial, sv.trace(initial), *traceBits)
+	}
+	if *explain {
+		printExplanation(os.Stdout, initial, sv.trace(initial))
+	}
+	if *partition {
+		printPartition(os.Stdout, len(initial.cells), sv.trace(initial))
+	}
+	if *regret {
+		greedy, _, _ := sv.firstSolution(initial)
+		ratio := 1.0
+		if result > 0 {
+			ratio = float64(greedy) / float64(result)
+		}
+		fmt.Printf("greedy: %d optimal: %d regret: %d (%.2fx)\n", greedy, result, greedy-result, ratio)
+	}
+	return nil
+}
+
+// configureSolver returns a solver for m, starting from initial, which is configured by the command-line flags that affect the answer, such
+// as -door-cost and -optional. Everything which solves a maze uses it, so that they all agree on the answer.
+func configureSolver(m *maze, initial state) (*solver, error) {
+	sv := newSolver(m, order)
+	sv.returnHome = *returnHome
+	sv.starts = initial.cells
+	sv.deterministic = *deterministic
+	if *seed != 0 {
+		sv.rng = rand.New(rand.NewSource(*seed))
+	}
+	active, err := parseActivation(*activation, len(initial.cells), m.keys)
+	if err != nil {
+		return nil, err
+	}
+	sv.activation = active
+	for i := 0; i < len(*optional); i++ {
+		if !m.keys.contains((*optional)[i]) {
+			return nil, fmt.Errorf("invalid optional key %q: not a key in the maze", (*optional)[i])
+		}
+		sv.goal = sv.goal &^ keyset(0).plus((*optional)[i])
+	}
+	if sv.removes, err = parseAntiKeys(*antiKeys, m.keys); err != nil {
+		return nil, err
+	}
+	if sv.before, err = parseCollectOrder(*collectOrder, m.keys); err != nil {
+		return nil, err
+	}
+	if sv.assigned, err = parseAssignment(*assign, len(initial.cells)); err != nil {
+		return nil, err
+	}
+	if *compressState {
+		if len(initial.cells) > maxCompactRobots {
+			return nil, fmt.Errorf("-compress-state supports at most %d robots", maxCompactRobots)
+		}
+		sv.compactTable = make(map[compactState]int)
+	}
+	if *doorCost < 0 {
+		return nil, fmt.Errorf("-door-cost must not be negative, not %d", *doorCost)
+	}
+	sv.doorCost = *doorCost
+	sv.consume = *consumeKeys
+	sv.maxRange = *maxRange
+	if *recall < 0 {
+		return nil, fmt.Errorf("-recall must not be negative, not %d", *recall)
+	}
+	sv.recall = *recall
+	if *fuel < 0 {
+		return nil, fmt.Errorf("-fuel must not be negative, not %d", *fuel)
+	}
+	sv.fuel = *fuel
+	if *cacheSegments {
+		sv.segments = make(map[segment][]path)
+	}
+	return sv, nil
+}
+
+// printKeys writes the keys in k to w in alphabetical order, either one per line or, if comma is true, on a single line separated by commas.
+func printKeys(w io.Writer, k keyset, comma bool) {
+	sep := "\n"
+	if comma {
+		sep = ","
+	}
+	if chars := k.String(); chars != "" {
+		fmt.Fprintln(w, strings.Join(strings.Split(chars, ""), sep))
+	}
+}
+
+// parseActivation parses the value of the -activation flag, which is a comma-separated list of robot:key pairs, and returns a map from the
+// index of each robot which starts inactive to the key which activates it. robots is the number of robots, and keys is the set of keys in the maze.
+func parseActivation(value string, robots int, keys keyset) (map[int]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	activation := make(map[int]byte)
+	for _, pair := range strings.Split(value, ",") {
+		robot, char, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid activation %q: want robot:key", pair)
+		}
+		i, err := strconv.Atoi(robot)
+		if err != nil || i < 1 || i > robots {
+			return nil, fmt.Errorf("invalid activation %q: robot must be between 1 and %d", pair, robots)
+		}
+		if len(char) != 1 || !keys.contains(char[0]) {
+			return nil, fmt.Errorf("invalid activation %q: %q is not a key in the maze", pair, char)
+		}
+		activation[i-1] = char[0]
+	}
+	return activation, nil
+}
+
+// parseAssignment parses the value of the -assign flag, which is a semicolon-separated list of robot:keys pairs, and returns a map from
+// the index of each restricted robot to the set of keys it may collect. Each set of keys is a comma-separated list of keys and ranges of
+// keys, such as a-f,x. robots is the number of robots.
+func parseAssignment(value string, robots int) (map[int]keyset, error) {
+	if value == "" {
+		return nil, nil
+	}
+	assigned := make(map[int]keyset)
+	for _, pair := range strings.Split(value, ";") {
+		robot, spec, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid assignment %q: want robot:keys", pair)
+		}
+		i, err := strconv.Atoi(robot)
+		if err != nil || i < 1 || i > robots {
+			return nil, fmt.Errorf("invalid assignment %q: robot must be between 1 and %d", pair, robots)
+		}
+		for _, item := range strings.Split(spec, ",") {
+			from, to, isRange := strings.Cut(item, "-")
+			if !isRange {
+				to = from
+			}
+			if len(from) != 1 || len(to) != 1 || from[0] < 'a' || to[0] > 'z' || from[0] > to[0] {
+				return nil, fmt.Errorf("invalid assignment %q: %q is not a key or range of keys", pair, item)
+			}
+			for char := from[0]; char <= to[0]; char++ {
+				assigned[i-1] = assigned[i-1].plus(char)
+			}
+		}
+	}
+	return assigned, nil
+}
+
+// parseAntiKeys parses the value of the -anti-keys flag, which is a comma-separated list of anti:removed pairs, and returns a map from each
+// anti-key to the set of keys that collecting it removes. keys is the set of keys in the maze. To guarantee that the search terminates,
+// a key which is removed by an anti-key must not itself be an anti-key: then each anti-key is collected only once, and the keys held only
+// ever grow between collecting one anti-key and the next.
+func parseAntiKeys(value string, keys keyset) (map[byte]keyset, error) {
+	if value == "" {
+		return nil, nil
+	}
+	removes := make(map[byte]keyset)
+	for _, pair := range strings.Split(value, ",") {
+		anti, removed, ok := strings.Cut(pair, ":")
+		if !ok || len(anti) != 1 || len(removed) != 1 {
+			return nil, fmt.Errorf("invalid anti-key %q: want anti:removed, where each is a single key", pair)
+		}
+		for _, char := range []byte{anti[0], removed[0]} {
+			if !keys.contains(char) {
+				return nil, fmt.Errorf("invalid anti-key %q: %c is not a key in the maze", pair, char)
+			}
+		}
+		if anti == removed {
+			return nil, fmt.Errorf("invalid anti-key %q: a key can't remove itself", pair)
+		}
+		removes[anti[0]] = removes[anti[0]].plus(removed[0])
+	}
+	for anti := byte('a'); anti <= 'z'; anti++ {
+		for _, char := range removes[anti].chars() {
+			if _, ok := removes[char]; ok {
+				return nil, fmt.Errorf("invalid anti-key %c:%c: %c is itself an anti-key", anti, char, char)
+			}
+		}
+	}
+	return removes, nil
+}
+
+// parseCollectOrder parses the value of the -collect-order flag, which is a comma-separated list of constraints of the form a<b, and returns
+// a map from each key which must be collected after some other key to the set of keys which must be collected before it.
+func parseCollectOrder(value string, keys keyset) (map[byte]keyset, error) {
+	if value == "" {
+		return nil, nil
+	}
+	before := make(map[byte]keyset)
+	for _, constraint := range strings.Split(value, ",") {
+		first, then, ok := strings.Cut(constraint, "<")
+		if !ok || len(first) != 1 || len(then) != 1 {
+			return nil, fmt.Errorf("invalid collection order %q: want a<b, where each is a single key", constraint)
+		}
+		for _, char := range []byte{first[0], then[0]} {
+			if !keys.contains(char) {
+				return nil, fmt.Errorf("invalid collection order %q: %c is not a key in the maze", constraint, char)
+			}
+		}
+		if first == then {
+			return nil, fmt.Errorf("invalid collection order %q: a key can't be collected before itself", constraint)
+		}
+		before[then[0]] = before[then[0]].plus(first[0])
+	}
+	return before, nil
+}
+
+// checkRobots returns an error if a maze with the given number of start cells is not suitable for solving with want robots.
+// If want is zero, any number of robots other than zero is acceptable.
+func checkRobots(starts, want int) error {
+	switch {
+	case starts == 0:
+		return errors.New("maze has no start cells")
+	case want > 0 && starts != want:
+		return fmt.Errorf("maze has %d start cells, but -robots=%d", starts, want)
+	}
+	return nil
+}
+
+// formatAnswer returns n formatted according to the -sep and -hex flags, which are ignored under -answer-only.
+func formatAnswer(n int) string {
+	switch {
+	case *answerOnly:
+	case *hex:
+		return strconv.FormatInt(int64(n), 16)
+	case *sep:
+		return groupThousands(strconv.Itoa(n))
+	}
+	return strconv.Itoa(n)
+}
+
+// groupThousands inserts a comma between each group of three digits in the decimal string digits.
+func groupThousands(digits string) string {
+	var sign string
+	if len(digits) > 0 && digits[0] == '-' {
+		sign, digits = "-", digits[1:]
+	}
+	b := make([]byte, 0, len(digits)+len(digits)/3)
+	for i := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b = append(b, ',')
+		}
+		b = append(b, digits[i])
+	}
+	return sign + string(b)
+}
+
+// maze represents the maze.
+type maze struct {
+	w, h         int
+	rows         [][]*cell
+	keys         keyset
+	connectivity string // which cells are neighbours: "4" (or ""), "8" or "hex" - see neighbours
+	slashes      bool   // whether '/' and '\' are diagonal passages - see joins
+}
+
+// parseOptions controls how readMaze interprets its input.
+type parseOptions struct {
+	format        string // the format of the input: "text" (or ""), "rle", "tokens", "legend", "portals" or "gob" - see the package documentation
+	oneWay        bool   // parse '>', '<', '^' and 'v' as one-way passages rather than floor and keys
+	keysNeedDoors bool   // treat keys with no matching door as empty cells
+	maxKeys       int    // the maximum number of distinct keys allowed in the maze, or 0 for no limit
+	connectivity  string // which cells are neighbours: "4" (or ""), "8" or "hex" - see maze.neighbours
+	transpose     bool   // read the input as columns rather than rows
+	slashes       bool   // parse '/' and '\' as passages which only join the cells at either end of the slash
+	reveals       string // hidden passages, which are revealed by collecting keys - see maze.addHiddenPassages
+	maxWidth      int    // the maximum width of the maze, or 0 for no limit
+	maxHeight     int    // the maximum height of the maze, or 0 for no limit
+	maxCells      int    // the maximum number of cells in the maze, including walls, or 0 for no limit
+}
+
+// errTooLarge is returned by readMaze and parseMaze if the maze is larger than the limits given by the parse options.
+var errTooLarge = errors.New("maze exceeds size limits")
+
+// checkSize returns errTooLarge if a maze w cells wide and h cells high is larger than the limits given by opts.
+func (opts parseOptions) checkSize(w, h int) error {
+	if opts.maxWidth > 0 && w > opts.maxWidth || opts.maxHeight > 0 && h > opts.maxHeight || opts.maxCells > 0 && w*h > opts.maxCells {
+		return errTooLarge
+	}
+	return nil
+}
+
+// maxRowLength returns the greatest number of cells a row of the input may have within the limits given by opts, or 0 if there is no limit.
+func (opts parseOptions) maxRowLength() int {
+	limit := opts.maxWidth
+	if opts.transpose {
+		limit = opts.maxHeight
+	}
+	if opts.maxCells > 0 && (limit == 0 || opts.maxCells < limit) {
+		limit = opts.maxCells
+	}
+	return limit
+}
+
+// rowWidth returns the number of cells in a row of the input in the format given by opts, before any transposition.
+func (opts parseOptions) rowWidth(row string) (int, error) {
+	switch opts.format {
+	case "rle":
+		decoded, err := decodeRLE(row, opts.maxRowLength())
+		return len(decoded), err
+	case "tokens":
+		return len(strings.Fields(row)), nil
+	}
+	return utf8.RuneCountInString(row), nil
+}
+
+// readMaze reads a maze from r and returns it. The input is assumed to be a grid of cells, the width of which is given by its first row.
+// Shorter rows are padded with walls. Whatever the format, the size of the grid is checked against the limits in opts as each row is read,
+// so that reading stops as soon as the maze is known to be too large.
+func readMaze(r io.Reader, opts parseOptions) (*maze, error) {
+	if opts.format == "gob" {
+		m, err := LoadMaze(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := opts.checkSize(m.w, m.h); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	var rows []string
+	first := 0 // the index of the first row of the grid, after any legend
+	legend := opts.format == "legend"
+	var width int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rows = append(rows, scanner.Text())
+		if legend {
+			if rows[len(rows)-1] == "" {
+				legend, first = false, len(rows)
+			}
+			continue
+		}
+		if len(rows) == first+1 {
+			var err error
+			if width, err = opts.rowWidth(rows[first]); err != nil {
+				return nil, fmt.Errorf("row 1: %w", err)
+			}
+		}
+		w, h := width, len(rows)-first
+		if opts.transpose {
+			w, h = h, w
+		}
+		if err := opts.checkSize(w, h); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseMaze(rows, opts)
+}
+
+// gunzipIfCompressed returns a reader which decompresses r if it starts with the gzip magic number, or otherwise returns the contents of r unchanged.
+func gunzipIfCompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+// decodeBase64 reads base64 from r, in either the standard or the URL-safe alphabet, with or without padding, and ignoring any whitespace, and
+// returns a reader for the decoded input, which is also decompressed if it is gzipped.
+func decodeBase64(r io.Reader) (io.Reader, error) {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimRight(strings.Join(strings.Fields(string(input)), ""), "=")
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "/").Replace(text))
+	if err != nil {
+		return nil, fmt.Errorf("input is not valid base64: %w", err)
+	}
+	return gunzipIfCompressed(bytes.NewReader(decoded))
+}
+
+// readMazes reads a stream of mazes from r, separated by lines equal to delimiter, and calls f with the rows of each maze as soon as it has been read.
+// Empty mazes are skipped, and the final maze need not be followed by a delimiter. A maze is only passed to f once its delimiter or the end of
+// the input has been read, so it doesn't matter how the input is split between reads, as long as each maze is complete by then - as when
+// the input is a named pipe written in chunks. It returns any error encountered while reading, after passing f the rows read before it.
+func readMazes(r io.Reader, delimiter string, f func(rows []string)) error {
+	var rows []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != delimiter {
+			rows = append(rows, line)
+			continue
+		}
+		if len(rows) > 0 {
+			f(rows)
+		}
+		rows = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		f(rows)
+	}
+	return nil
+}
+
+// parseMaze builds a maze from rows, each of which is a row of characters in the grid.
+// Each rune is a single cell, and any non-ASCII symbol is treated as a wall, so mazes may be drawn with multi-byte glyphs such as emoji.
+// Any other non-ASCII rune is an error, rather than being silently walled up, since it is probably meant to be a key or a door.
+func parseMaze(rows []string, opts parseOptions) (*maze, error) {
+	switch opts.format {
+	case "", "text":
+	case "rle":
+		decoded := make([]string, len(rows))
+		for i := range rows {
+			row, err := decodeRLE(rows[i], opts.maxRowLength())
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i+1, err)
+			}
+			decoded[i] = row
+		}
+		rows = decoded
+	case "tokens":
+		decoded := make([]string, len(rows))
+		for i := range rows {
+			row, err := decodeTokens(rows[i])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i+1, err)
+			}
+			decoded[i] = row
+		}
+		rows = decoded
+	case "legend":
+		decoded, err := decodeLegend(rows)
+		if err != nil {
+			return nil, err
+		}
+		rows = decoded
+	case "portals":
+		if opts.transpose {
+			rows = transposeRows(rows)
+		}
+		return parsePortalMaze(rows)
+	default:
+		return nil, fmt.Errorf("unknown input format %q", opts.format)
+	}
+	if opts.transpose {
+		rows = transposeRows(rows)
+	}
+	switch opts.connectivity {
+	case "", "4", "8", "hex":
+	default:
+		return nil, fmt.Errorf("unknown connectivity %q", opts.connectivity)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("maze is empty")
+	}
+	if err := opts.checkSize(utf8.RuneCountInString(rows[0]), len(rows)); err != nil {
+		return nil, err
+	}
+	if n := rowKeys(rows, opts).len(); opts.maxKeys > 0 && n > opts.maxKeys {
+		return nil, fmt.Errorf("maze has %d keys; solver supports at most %d", n, opts.maxKeys)
+	}
+	m := newMaze(utf8.RuneCountInString(rows[0]), len(rows))
+	m.connectivity = opts.connectivity
+	m.slashes = opts.slashes
+	for i := range rows {
+		if w := utf8.RuneCountInString(rows[i]); w > m.w {
+			return nil, fmt.Errorf("row %d has width %d, but the maze has width %d", i+1, w, m.w)
+		}
+		for j, r := range []rune(rows[i]) {
+			if r >= utf8.RuneSelf && !unicode.IsSymbol(r) {
+				return nil, fmt.Errorf("row %d, column %d: %q is neither ASCII nor a symbol which can be used as a wall", i+1, j+1, r)
+			}
+			if r != '#' && r < utf8.RuneSelf {
+				char := byte(r)
+				c := newCell(char)
+				if opts.oneWay {
+					c.exit = oneWayExit(char)
+					if c.exit != anyDirection {
+						c.cellType = empty
+					}
+				}
+				m.addCell(i, j, c)
+			}
+		}
+	}
+	if err := m.addHiddenPassages(opts.reveals); err != nil {
+		return nil, err
+	}
+	if opts.keysNeedDoors {
+		m.dropUnpairedKeys()
+	}
+	m.buildPaths()
+	return m, nil
+}
+
+// rowKeys returns the keys in rows as parseMaze classifies them, so that they can be counted before any cells are built: each lower-case
+// letter, apart from 'v' if it is a one-way passage, and only if its door is in rows too if opts.keysNeedDoors is set.
+func rowKeys(rows []string, opts parseOptions) keyset {
+	var keys, doors keyset
+	for _, row := range rows {
+		for i := 0; i < len(row); i++ {
+			switch char := row[i]; {
+			case opts.oneWay && oneWayExit(char) != anyDirection:
+			case 'a' <= char && char <= 'z':
+				keys = keys.plus(char)
+			case 'A' <= char && char <= 'Z':
+				doors = doors.plus(char | 32)
+			}
+		}
+	}
+	if opts.keysNeedDoors {
+		keys &= doors
+	}
+	return keys
+}
+
+// addHiddenPassages joins the pairs of cells listed in reveals by hidden passages, each of which can only be followed once a given key has
+// been collected. reveals is a semicolon-separated list of passages of the form "key:r1,c1:r2,c2", where both cells must be open.
+func (m *maze) addHiddenPassages(reveals string) error {
+	if reveals == "" {
+		return nil
+	}
+	for _, passage := range strings.Split(reveals, ";") {
+		fields := strings.Split(passage, ":")
+		if len(fields) != 3 || len(fields[0]) != 1 || !m.keys.contains(fields[0][0]) {
+			return fmt.Errorf("invalid hidden passage %q: want key:r1,c1:r2,c2", passage)
+		}
+		var ends [2]*cell
+		for i, pos := range fields[1:] {
+			var r, c int
+			if _, err := fmt.Sscanf(pos, "%d,%d", &r, &c); err != nil {
+				return fmt.Errorf("invalid hidden passage %q: %w", passage, err)
+			}
+			if r < 0 || r >= m.h || c < 0 || c >= m.w || m.rows[r][c] == nil {
+				return fmt.Errorf("invalid hidden passage %q: %d,%d is not an open cell", passage, r, c)
+			}
+			ends[i] = m.rows[r][c]
+		}
+		ends[0].join(ends[1])
+		ends[0].hide(ends[1], fields[0][0])
+		ends[1].hide(ends[0], fields[0][0])
+	}
+	return nil
+}
+
+// transposeRows returns rows with its rows and columns swapped, so that each column of the input becomes a row of the result.
+// Rows shorter than the longest row are padded with walls.
+func transposeRows(rows []string) []string {
+	var w int
+	grid := make([][]rune, len(rows))
+	for i := range rows {
+		grid[i] = []rune(rows[i])
+		if len(grid[i]) > w {
+			w = len(grid[i])
+		}
+	}
+	transposed := make([]string, w)
+	for j := range transposed {
+		col := make([]rune, len(grid))
+		for i := range grid {
+			col[i] = '#'
+			if j < len(grid[i]) {
+				col[i] = grid[i][j]
+			}
+		}
+		transposed[j] = string(col)
+	}
+	return transposed
+}
+
+// decodeTokens decodes a row of whitespace-separated tokens, each of which is one of "wall" or "#", "floor" or ".", "start" or "@",
+// "key_x" for a key x, or "door_X" for a door X, into the equivalent row of characters.
+func decodeTokens(row string) (string, error) {
+	tokens := strings.Fields(row)
+	chars := make([]byte, len(tokens))
+	for i, token := range tokens {
+		switch {
+		case token == "wall" || token == "#":
+			chars[i] = '#'
+		case token == "floor" || token == ".":
+			chars[i] = '.'
+		case token == "start" || token == "@":
+			chars[i] = '@'
+		case len(token) == 5 && strings.HasPrefix(token, "key_") && 'a' <= token[4] && token[4] <= 'z':
+			chars[i] = token[4]
+		case len(token) == 6 && strings.HasPrefix(token, "door_") && 'A' <= token[5] && token[5] <= 'Z':
+			chars[i] = token[5]
+		default:
+			return "", fmt.Errorf("unknown token %q", token)
+		}
+	}
+	return string(chars), nil
+}
+
+// decodeRLE decodes a run-length encoded row, in which each character may be preceded by a decimal count of the number of times it is repeated.
+// For example, "3#.@2.a3#" decodes to "###.@..a###". If limit is not 0, errTooLarge is returned as soon as the decoded row would be longer than
+// limit, before it is expanded.
+func decodeRLE(row string, limit int) (string, error) {
+	var b strings.Builder
+	count, n := -1, 0
+	for _, r := range row {
+		if '0' <= r && r <= '9' {
+			if count < 0 {
+				count = 0
+			}
+			if count > (math.MaxInt-9)/10 {
+				return "", errors.New("run-length encoded count is too large")
+			}
+			count = count*10 + int(r-'0')
+			continue
+		}
+		if count < 0 {
+			count = 1
+		}
+		if count > math.MaxInt-n || limit > 0 && n+count > limit {
+			return "", errTooLarge
+		}
+		n += count
+		b.WriteString(strings.Repeat(string(r), count))
+		count = -1
+	}
+	if count >= 0 {
+		return "", errors.New("run-length encoded row ends with a count")
+	}
+	return b.String(), nil
+}
+
+// newMaze initialises a new maze with width w and height h.
+func newMaze(w, h int) *maze {
+	cells := make([]*cell, w*h)
+	rows := make([][]*cell, h)
+	for i := range rows {
+		rows[i] = cells[i*w : (i+1)*w]
+	}
+	return &maze{w: w, h: h, rows: rows}
+}
+
+// addCell adds c to m at row i and column j, connecting c to any neighbours and, if c is a key, adds its value to m's keyset.
+func (m *maze) addCell(i, j int, c *cell) {
+	m.rows[i][j] = c
+	c.row, c.col = i, j
+	offsets := m.neighbours(i)
+	if m.slashes {
+		offsets = append(orthogonal[:4:4], diagonal...)
+	}
+	for _, o := range offsets {
+		i1, j1 := i+o.di, j+o.dj
+		if 0 <= i1 && i1 < m.h && 0 <= j1 && j1 < m.w && m.rows[i1][j1] != nil {
+			c1 := m.rows[i1][j1]
+			if !m.slashes || m.joins(c, c1, o) && m.joins(c1, c, offset{-o.di, -o.dj, o.d.opposite()}) {
+				c.connect(c1, o.d)
+			}
+		}
+	}
+	if c.cellType == key {
+		m.keys = m.keys.plus(c.char)
+	}
+}
+
+// offset is the position of a neighbouring cell relative to a cell, and the direction in which it lies.
+type offset struct {
+	di, dj int
+	d      direction
+}
+
+var (
+	orthogonal = []offset{{-1, 0, north}, {0, -1, west}, {1, 0, south}, {0, 1, east}}
+	diagonal   = []offset{{-1, -1, northWest}, {-1, 1, northEast}, {1, -1, southWest}, {1, 1, southEast}}
+)
+
+// neighbours returns the offsets of the cells which neighbour a cell in row i of m, according to m's connectivity.
+// With "4" connectivity, each cell has four neighbours: those to the north, west, south and east. With "8" connectivity, the four diagonal
+// cells are neighbours as well. With "hex" connectivity, the grid is read as hexagonal cells in which odd rows are shifted half a cell to
+// the right of even rows, so each cell has six neighbours: those to the west and east, and two in each of the rows above and below,
+// which are in columns j and j+1 for a cell in an odd row, and columns j-1 and j for a cell in an even row (counting rows from 0).
+func (m *maze) neighbours(i int) []offset {
+	switch m.connectivity {
+	case "8":
+		return append(orthogonal[:4:4], diagonal...)
+	case "hex":
+		if i%2 == 1 {
+			return append(orthogonal[:4:4], diagonal[1], diagonal[3])
+		}
+		return append(orthogonal[:4:4], diagonal[0], diagonal[2])
+	}
+	return orthogonal
+}
+
+// slashOffsets gives the diagonal neighbours of the glyphs '/' and '\', which are only joined to the cells at either end of the slash.
+var slashOffsets = map[byte][]offset{
+	'/':  {diagonal[1], diagonal[2]},
+	'\\': {diagonal[0], diagonal[3]},
+}
+
+// joins returns true if c is willing to be joined to its neighbour c1, which lies at offset o from c, when m.slashes is set. A slash cell is
+// only joined to the cells at either end of the slash, and any other cell is joined to its usual neighbours (see neighbours) and to a
+// diagonal neighbour which is a slash cell.
+func (m *maze) joins(c, c1 *cell, o offset) bool {
+	if offsets, ok := slashOffsets[c.char]; ok {
+		for _, o1 := range offsets {
+			if o1 == o {
+				return true
+			}
+		}
+		return false
+	}
+	if _, ok := slashOffsets[c1.char]; ok && o.di != 0 && o.dj != 0 {
+		return true
+	}
+	for _, o1 := range m.neighbours(c.row) {
+		if o1 == o {
+			return true
+		}
+	}
+	return false
+}
+
+// dropUnpairedKeys reclassifies each key in m which has no matching door as an empty cell, and removes it from m's keyset.
+func (m *maze) dropUnpairedKeys() {
+	var doors keyset
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && c.cellType == door {
+				doors = doors.plus(c.char | 32)
+			}
+		}
+	}
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && c.cellType == key && !doors.contains(c.char) {
+				c.cellType = empty
+			}
+		}
+	}
+	m.keys &= doors
+}
+
+// concatHorizontally returns a new maze consisting of a and b side by side, separated by a column of walls.
+// The mazes must have the same height, connectivity and treatment of slashes, and must not contain any of the same keys. Adjacency is copied
+// from a and b rather than recalculated, as in transform, so portals, one-way passages and hidden passages are preserved.
+func concatHorizontally(a, b *maze) (*maze, error) {
+	if a.h != b.h {
+		return nil, fmt.Errorf("cannot join mazes of height %d and %d", a.h, b.h)
+	}
+	if a.connectivity != b.connectivity || a.slashes != b.slashes {
+		return nil, errors.New("cannot join mazes with different connectivity or slashes")
+	}
+	if shared := a.keys & b.keys; shared != 0 {
+		return nil, fmt.Errorf("cannot join mazes which share the keys %s", shared)
+	}
+	m := newMaze(a.w+1+b.w, a.h)
+	m.connectivity, m.slashes = a.connectivity, a.slashes
+	copies := make(map[*cell]*cell)
+	for _, part := range []struct {
+		m   *maze
+		col int
+	}{{a, 0}, {b, a.w + 1}} {
+		for i := range part.m.rows {
+			for j, c := range part.m.rows[i] {
+				if c == nil {
+					continue
+				}
+				c1 := c.copy()
+				c1.row, c1.col = i, part.col+j
+				m.rows[i][c1.col] = c1
+				if c1.cellType == key {
+					m.keys = m.keys.plus(c1.char)
+				}
+				copies[c] = c1
+			}
+		}
+	}
+	for c, c1 := range copies {
+		for _, adj := range c.adj {
+			c1.link(copies[adj])
+			if char, ok := c.hidden[adj]; ok {
+				c1.hide(copies[adj], char)
+			}
+		}
+	}
+	m.buildPaths()
+	return m, nil
+}
+
+// rect is a rectangle of cells, from row r1 and column c1 to row r2 and column c2 inclusive.
+type rect struct {
+	r1, c1, r2, c2 int
+}
+
+// contains returns true if the cell at row i and column j lies within r.
+func (r rect) contains(i, j int) bool {
+	return r.r1 <= i && i <= r.r2 && r.c1 <= j && j <= r.c2
+}
+
+// parseRegion parses the value of the -region flag, which is of the form r1,c1,r2,c2.
+func parseRegion(value string) (rect, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 4 {
+		return rect{}, fmt.Errorf("invalid region %q: want r1,c1,r2,c2", value)
+	}
+	var n [4]int
+	for i, field := range fields {
+		var err error
+		if n[i], err = strconv.Atoi(field); err != nil {
+			return rect{}, fmt.Errorf("invalid region %q: %w", value, err)
+		}
+	}
+	r := rect{min(n[0], n[2]), min(n[1], n[3]), max(n[0], n[2]), max(n[1], n[3])}
+	return r, nil
+}
+
+// crop returns a copy of m with the same dimensions, in which every cell outside r is a wall. Cells inside r keep their adjacency to each
+// other, so portals and one-way passages within r are preserved, and keys outside r are excluded from the copy's keys. It returns an error
+// if r contains no start cell.
+func (m *maze) crop(r rect) (*maze, error) {
+	t := newMaze(m.w, m.h)
+	t.connectivity = m.connectivity
+	copies := make(map[*cell]*cell)
+	for i := range m.rows {
+		for j, c := range m.rows[i] {
+			if c == nil || !r.contains(i, j) {
+				continue
+			}
+			c1 := c.copy()
+			c1.row, c1.col = i, j
+			t.rows[i][j] = c1
+			if c1.cellType == key {
+				t.keys = t.keys.plus(c1.char)
+			}
+			copies[c] = c1
+		}
+	}
+	for c, c1 := range copies {
+		for _, adj := range c.adj {
+			if adj1, ok := copies[adj]; ok {
+				c1.link(adj1)
+				if char, ok := c.hidden[adj]; ok {
+					c1.hide(adj1, char)
+				}
+			}
+		}
+	}
+	if len(t.start()) == 0 {
+		return nil, fmt.Errorf("region %d,%d,%d,%d contains no start cell", r.r1, r.c1, r.r2, r.c2)
+	}
+	t.buildPaths()
+	return t, nil
+}
+
+// transpose returns a copy of m with its rows and columns swapped.
+func (m *maze) transpose() *maze {
+	return m.transform(m.h, m.w, func(i, j int) (int, int) { return j, i }, func(d direction) direction {
+		switch d {
+		case north:
+			return west
+		case west:
+			return north
+		case south:
+			return east
+		case east:
+			return south
+		}
+		return d
+	})
+}
+
+// mirror returns a copy of m reflected from left to right.
+func (m *maze) mirror() *maze {
+	return m.transform(m.w, m.h, func(i, j int) (int, int) { return i, m.w - 1 - j }, func(d direction) direction {
+		switch d {
+		case west:
+			return east
+		case east:
+			return west
+		}
+		return d
+	})
+}
+
+// transform returns a copy of m with width w and height h, in which the cell at row i and column j of m is moved to the position given by pos(i, j),
+// and the exit direction of each one-way cell is mapped by dir. Adjacency is copied from m rather than recalculated, so portals are preserved.
+func (m *maze) transform(w, h int, pos func(i, j int) (int, int), dir func(d direction) direction) *maze {
+	t := newMaze(w, h)
+	t.connectivity = m.connectivity
+	copies := make(map[*cell]*cell)
+	for i := range m.rows {
+		for j, c := range m.rows[i] {
+			if c == nil {
+				continue
+			}
+			c1 := c.copy()
+			c1.exit = dir(c.exit)
+			c1.row, c1.col = pos(i, j)
+			t.rows[c1.row][c1.col] = c1
+			if c1.cellType == key {
+				t.keys = t.keys.plus(c1.char)
+			}
+			copies[c] = c1
+		}
+	}
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c == nil {
+				continue
+			}
+			for _, adj := range c.adj {
+				copies[c].link(copies[adj])
+				if char, ok := c.hidden[adj]; ok {
+					copies[c].hide(copies[adj], char)
+				}
+			}
+		}
+	}
+	t.buildPaths()
+	return t
+}
+
+// randomSymmetry returns a copy of m which has been transposed, mirrored or both, chosen at random, along with a description of the transformation
+// and a function which gives the position in the copy of the cell at row i and column j of m.
+func randomSymmetry(m *maze) (string, *maze, func(i, j int) (int, int)) {
+	switch rand.Intn(3) {
+	case 0:
+		return "transposed", m.transpose(), func(i, j int) (int, int) { return j, i }
+	case 1:
+		return "mirrored", m.mirror(), func(i, j int) (int, int) { return i, m.w - 1 - j }
+	}
+	return "rotated", m.transpose().mirror(), func(i, j int) (int, int) { return j, m.h - 1 - i }
+}
+
+// printEdges writes a line to w for each edge between adjacent cells in m. Edges which can be traversed in both directions are written once.
+func printEdges(w io.Writer, m *maze) {
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c == nil {
+				continue
+			}
+			for _, adj := range c.adj {
+				switch {
+				case !adj.linksTo(c):
+					fmt.Fprintf(w, "%d,%d -> %d,%d\n", c.row, c.col, adj.row, adj.col)
+				case c.row < adj.row || c.row == adj.row && c.col < adj.col:
+					fmt.Fprintf(w, "%d,%d - %d,%d\n", c.row, c.col, adj.row, adj.col)
+				}
+			}
+		}
+	}
+}
+
+// printDiff prints the position of each cell which differs between a and b as a line of the form "r,c: x -> y", where x and y are the
+// characters of the cell in a and b. Walls are printed as '#'. It returns an error if the mazes have different dimensions.
+func printDiff(w io.Writer, a, b *maze) error {
+	if a.w != b.w || a.h != b.h {
+		return fmt.Errorf("cannot compare a %dx%d maze with a %dx%d maze", a.w, a.h, b.w, b.h)
+	}
+	for i := range a.rows {
+		for j := range a.rows[i] {
+			if x, y := a.char(i, j), b.char(i, j); x != y {
+				fmt.Fprintf(w, "%d,%d: %c -> %c\n", i, j, x, y)
+			}
+		}
+	}
+	return nil
+}
+
+// char returns the character of the cell at row i and column j of m, or '#' if it is a wall.
+func (m *maze) char(i, j int) byte {
+	if c := m.rows[i][j]; c != nil {
+		return c.char
+	}
+	return '#'
+}
+
+// TypeGrid returns the type of each cell in m, row by row, with wall for the walls.
+func (m *maze) TypeGrid() [][]cellType {
+	grid := make([][]cellType, len(m.rows))
+	for i := range m.rows {
+		grid[i] = make([]cellType, len(m.rows[i]))
+		for j, c := range m.rows[i] {
+			if c == nil {
+				grid[i][j] = wall
+			} else {
+				grid[i][j] = c.cellType
+			}
+		}
+	}
+	return grid
+}
+
+// start returns a slice containing all start cells in m.
+func (m *maze) start() []*cell {
+	var startCells []*cell
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && c.cellType == start {
+				startCells = append(startCells, c)
+			}
+		}
+	}
+	return startCells
+}
+
+// buildPaths populates the path list for each start and key cell in m.
+func (m *maze) buildPaths() {
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && (c.cellType == key || c.cellType == start) {
+				c.paths = findPaths(c)
+			}
+		}
+	}
+}
+
+// keyDiameter returns the greatest distance between any pair of keys in m, ignoring doors. Keys which can't reach each other are ignored.
+func (m *maze) keyDiameter() int {
+	var max int
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c == nil || c.cellType != key {
+				continue
+			}
+			for _, p := range c.paths {
+				if p.dest.cellType == key && p.len > max {
+					max = p.len
+				}
+			}
+		}
+	}
+	return max
+}
+
+// freeKeys returns the set of keys which can be collected from some start cell in m without opening any doors.
+func (m *maze) freeKeys() keyset {
+	var free keyset
+	for _, c := range m.start() {
+		for _, p := range c.paths {
+			if p.dest.cellType == key && p.reqKeys == 0 {
+				free = free.plus(p.dest.char)
+			}
+		}
+	}
+	return free
+}
+
+// junctions returns the empty cells in m which lead to three or more other cells, in row-major order.
+func (m *maze) junctions() []*cell {
+	var cells []*cell
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && c.cellType == empty && len(c.adj) >= 3 {
+				cells = append(cells, c)
+			}
+		}
+	}
+	return cells
+}
+
+// equivalentKeyGroups returns the groups of keys in m which are interchangeable, so that swapping any two keys in a group (and their doors)
+// leaves every path between start and key cells with the same length and required keys. Each group is in alphabetical order, the groups
+// are ordered by their first key, and keys which aren't interchangeable with any other key are omitted.
+func (m *maze) equivalentKeyGroups() [][]byte {
+	cells := make(map[byte]*cell)
+	var sources []*cell
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && (c.cellType == key || c.cellType == start) {
+				sources = append(sources, c)
+				if c.cellType == key {
+					cells[c.char] = c
+				}
+			}
+		}
+	}
+	paths := make(map[[2]*cell]path)
+	for _, c := range sources {
+		for _, p := range c.paths {
+			paths[[2]*cell{c, p.dest}] = p
+		}
+	}
+
+	// equivalent returns true if swapping the keys x and y maps every path onto a path with the same length and the swapped required keys.
+	equivalent := func(x, y byte) bool {
+		swap := func(c *cell) *cell {
+			switch c {
+			case cells[x]:
+				return cells[y]
+			case cells[y]:
+				return cells[x]
+			}
+			return c
+		}
+		swapKeys := func(k keyset) keyset {
+			swapped := k &^ keyset(0).plus(x).plus(y)
+			if k.contains(x) {
+				swapped = swapped.plus(y)
+			}
+			if k.contains(y) {
+				swapped = swapped.plus(x)
+			}
+			return swapped
+		}
+		for ends, p := range paths {
+			q, ok := paths[[2]*cell{swap(ends[0]), swap(ends[1])}]
+			if !ok || q.len != p.len || q.reqKeys != swapKeys(p.reqKeys) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var groups [][]byte
+	var grouped keyset
+	for _, x := range m.keys.chars() {
+		if grouped.contains(x) {
+			continue
+		}
+		group := []byte{x}
+		for _, y := range m.keys.chars() {
+			if y > x && !grouped.contains(y) && equivalent(x, y) {
+				group = append(group, y)
+				grouped = grouped.plus(y)
+			}
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// doorUnlockOrder returns the doors in m in the order in which they become openable, if the robots repeatedly collect every key they can
+// reach, ignoring distance. Doors which become openable in the same round are in alphabetical order, and doors which never become
+// openable (because their keys can't be reached) are omitted. The keys can all be collected if no door whose key is in m is omitted.
+func (m *maze) doorUnlockOrder() []byte {
+	var doors keyset
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && c.cellType == door {
+				doors = doors.plus(c.char | 32)
+			}
+		}
+	}
+	var order []byte
+	var keys keyset
+	for {
+		reached := keys
+		for i := range m.rows {
+			for _, c := range m.rows[i] {
+				if c == nil || c.cellType != start && !(c.cellType == key && keys.contains(c.char)) {
+					continue
+				}
+				for _, p := range c.paths {
+					if p.viable(keys) {
+						reached = reached.plus(p.dest.char)
+					}
+				}
+			}
+		}
+		if reached == keys {
+			return order
+		}
+		for _, char := range (reached &^ keys & doors).chars() {
+			order = append(order, char-'a'+'A')
+		}
+		keys = reached
+	}
+}
+
+// walk follows the given order of keys from the start cells of m, moving whichever robot is nearest to each key in turn (the first such
+// robot if there is a tie), and returns the total distance walked. It returns an error if a key isn't in m, is listed twice, or can't be
+// reached by any robot at that point, either because it is behind a door which hasn't been opened or because no robot can reach it at all.
+func (m *maze) walk(order []byte) (int, error) {
+	s := state{cells: m.start(), keys: 0}
+	var total int
+	for n, char := range order {
+		if !m.keys.contains(char) {
+			return 0, fmt.Errorf("move %d: %q is not a key in the maze", n+1, char)
+		}
+		if s.keys.contains(char) {
+			return 0, fmt.Errorf("move %d: key %c has already been collected", n+1, char)
+		}
+		var best step
+		var blocked keyset
+		found, reachable := false, false
+		for i, c := range s.cells {
+			for _, p := range c.paths {
+				if p.dest.char != char || p.dest.cellType != key {
+					continue
+				}
+				reachable = true
+				if !s.keys.containsAll(p.reqKeys) {
+					blocked |= p.reqKeys &^ s.keys
+					continue
+				}
+				if !found || p.len < best.path.len {
+					best, found = step{robot: i, path: p}, true
+				}
+			}
+		}
+		switch {
+		case !reachable:
+			return 0, fmt.Errorf("move %d: no robot can reach key %c", n+1, char)
+		case !found:
+			return 0, fmt.Errorf("move %d: key %c is behind the unopened doors %s", n+1, char, strings.ToUpper(blocked.String()))
+		}
+		total += best.path.len
+		s = s.next(best)
+	}
+	return total, nil
+}
+
+// shortestPathOrdered returns the length of the shortest route through m which collects its keys in alphabetical order. Each robot may
+// still pass over keys on its way to the next, but only collects them in turn. It returns an error wrapping errUnsolvable if some key is
+// blocked by a door whose key comes later in the alphabet, or can't be reached at all.
+func shortestPathOrdered(m *maze) (int, error) {
+	sv := newSolver(m, order)
+	sv.before = make(map[byte]keyset)
+	var earlier keyset
+	for _, char := range m.keys.chars() {
+		sv.before[char] = earlier
+		earlier = earlier.plus(char)
+	}
+	return sv.solve(context.Background(), state{cells: m.start(), keys: 0})
+}
+
+// keyBounds returns the smallest rectangle containing every key in m, from row r1 and column c1 to row r2 and column c2 inclusive,
+// in the form accepted by -region. If m has no keys, all four are -1.
+func (m *maze) keyBounds() (r1, c1, r2, c2 int) {
+	r1, c1, r2, c2 = -1, -1, -1, -1
+	for i := range m.rows {
+		for j, c := range m.rows[i] {
+			if c == nil || c.cellType != key {
+				continue
+			}
+			if r1 < 0 {
+				r1, c1, r2, c2 = i, j, i, j
+			}
+			r1, c1, r2, c2 = min(r1, i), min(c1, j), max(r2, i), max(c2, j)
+		}
+	}
+	return r1, c1, r2, c2
+}
+
+// manhattanBound returns the Manhattan distance between the keys a and b in m, ignoring walls, or -1 if either key isn't in m. Since each
+// step moves one cell up, down, left or right, this is a lower bound on the length of any path between them - unless the maze has diagonal
+// connectivity, portals or hidden passages, any of which can take a path more than one row or column in a single step.
+func (m *maze) manhattanBound(a, b byte) int {
+	var ca, cb *cell
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && c.cellType == key && c.char == a {
+				ca = c
+			}
+			if c != nil && c.cellType == key && c.char == b {
+				cb = c
+			}
+		}
+	}
+	if ca == nil || cb == nil {
+		return -1
+	}
+	return abs(ca.row-cb.row) + abs(ca.col-cb.col)
+}
+
+// abs returns the absolute value of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// reachableCellCount returns the number of cells in m, other than c itself, which can be reached from c, ignoring doors.
+// It measures how open the area around c is: a key in a small pocket has few reachable cells, and a key in a large hall has many.
+func (m *maze) reachableCellCount(c *cell) int {
+	var n int
+	bfs(c, func(*cell, int) bool {
+		n++
+		return true
+	})
+	return n - 1
+}
+
+// components returns the connected components of the open cells in m, ignoring doors, each in row-major order, ordered by their first cell.
+// Two cells are in the same component if there is a chain of links between them in either direction, so a one-way cell doesn't split
+// a component, even though it can't be passed through both ways. A robot can only ever reach the keys in its own component.
+func (m *maze) components() [][]*cell {
+	parent := make(map[*cell]*cell)
+	var find func(c *cell) *cell
+	find = func(c *cell) *cell {
+		if p, ok := parent[c]; ok && p != c {
+			parent[c] = find(p)
+			return parent[c]
+		}
+		return c
+	}
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c == nil {
+				continue
+			}
+			for _, adj := range c.adj {
+				if r, r1 := find(c), find(adj); r != r1 {
+					parent[r1] = r
+				}
+			}
+		}
+	}
+	var components [][]*cell
+	index := make(map[*cell]int) // the index in components of the component with each root
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c == nil {
+				continue
+			}
+			r := find(c)
+			n, ok := index[r]
+			if !ok {
+				n = len(components)
+				index[r] = n
+				components = append(components, nil)
+			}
+			components[n] = append(components[n], c)
+		}
+	}
+	return components
+}
+
+// nearestKeys returns the nearest key which each robot in s can collect next, by robot index, or 0 for a robot which can't collect any key.
+// Ties are broken in favour of the alphabetically first key.
+func (m *maze) nearestKeys(s state) []byte {
+	nearest := make([]byte, len(s.cells))
+	for i, c := range s.cells {
+		best := noPath
+		for _, p := range c.paths {
+			if p.viable(s.keys) && (best == noPath || p.len < best || p.len == best && p.dest.char < nearest[i]) {
+				best, nearest[i] = p.len, p.dest.char
+			}
+		}
+	}
+	return nearest
+}
+
+// prerequisites returns the set of keys which must be collected before the key t: the keys to the doors on the shortest path to t from
+// the nearest start cell, ignoring other keys, and recursively their prerequisites. It returns an error wrapping errUnsolvable if t or
+// one of its prerequisites can't be reached from any start cell, or if the keys' dependencies form a cycle.
+func (m *maze) prerequisites(t byte) (keyset, error) {
+	var done keyset
+	var stack []byte // the keys being visited, each of which is a prerequisite of the one before it
+	var visit func(char byte) error
+	visit = func(char byte) error {
+		if done.contains(char) {
+			return nil
+		}
+		if i := bytes.IndexByte(stack, char); i >= 0 {
+			return fmt.Errorf("%w: %s", errUnsolvable, describeCycle(append(stack[i:], char)))
+		}
+		stack = append(stack, char)
+		p, ok := m.nearestPath(char)
+		if !ok {
+			return fmt.Errorf("%w: key %c is walled off entirely, so no start cell can reach it", errUnsolvable, char)
+		}
+		for _, req := range p.reqKeys.chars() {
+			if err := visit(req); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		done = done.plus(char)
+		return nil
+	}
+	if err := visit(t); err != nil {
+		return 0, err
+	}
+	return done &^ keyset(0).plus(t), nil
+}
+
+// dependencyDepth returns the length of the longest chain of dependencies between the keys in m, in which each key is behind the door of
+// the next (as found by prerequisites), counted in doors: 0 if every key can be reached without opening a door. It returns -1 if some key's
+// prerequisites can't be found, because it can't be reached or the dependencies form a cycle.
+func (m *maze) dependencyDepth() int {
+	for _, char := range m.keys.chars() {
+		if _, err := m.prerequisites(char); err != nil {
+			return -1
+		}
+	}
+	depths := make(map[byte]int)
+	var depth func(char byte) int
+	depth = func(char byte) int {
+		if d, ok := depths[char]; ok {
+			return d
+		}
+		var d int
+		p, _ := m.nearestPath(char)
+		for _, req := range p.reqKeys.chars() {
+			if n := 1 + depth(req); n > d {
+				d = n
+			}
+		}
+		depths[char] = d
+		return d
+	}
+	var max int
+	for _, char := range m.keys.chars() {
+		if d := depth(char); d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// unlockThreshold returns the smallest number of keys which must be collected before any key behind a door can be collected: the fewest
+// prerequisites (see prerequisites) of any key which has at least one. It returns 0 if no key is behind a door, and -1 if no key behind
+// a door can ever be collected.
+func (m *maze) unlockThreshold() int {
+	threshold := -1
+	gated := false
+	for _, char := range m.keys.chars() {
+		if p, ok := m.nearestPath(char); !ok || p.reqKeys == 0 {
+			continue
+		}
+		gated = true
+		if reqs, err := m.prerequisites(char); err == nil && (threshold == -1 || reqs.len() < threshold) {
+			threshold = reqs.len()
+		}
+	}
+	if !gated {
+		return 0
+	}
+	return threshold
+}
+
+// describeCycle describes a cycle of dependencies between keys, given as a list of keys each of which is behind the door of the next, and
+// the last of which is the same as the first - for example "key q is behind door M, but key m is behind door Q (cyclic dependency)".
+func describeCycle(cycle []byte) string {
+	var parts []string
+	for i := 0; i+1 < len(cycle); i++ {
+		parts = append(parts, fmt.Sprintf("key %c is behind door %c", cycle[i], cycle[i+1]-'a'+'A'))
+	}
+	return strings.Join(parts, ", but ") + " (cyclic dependency)"
+}
+
+// explainUnsolvable returns an error describing why the keys in m can't all be collected, found by checking the prerequisites of each key
+// in turn (see prerequisites), or nil if no obstruction is found that way - as when the keys can only be collected in an order which the
+// shortest paths between them don't allow, or the solver has been restricted by flags such as -assign.
+func (m *maze) explainUnsolvable() error {
+	for _, char := range m.keys.chars() {
+		if _, err := m.prerequisites(char); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nearestPath returns the shortest path from any start cell in m to the key char, ignoring doors, and false if there is no such path.
+func (m *maze) nearestPath(char byte) (path, bool) {
+	var nearest path
+	var found bool
+	for _, c := range m.start() {
+		for _, p := range c.paths {
+			if p.dest.char == char && (!found || p.len < nearest.len) {
+				nearest, found = p, true
+			}
+		}
+	}
+	return nearest, found
+}
+
+// Difficulty returns a rough estimate of how hard m is to solve, without solving it, so that mazes can be sorted from easiest to hardest.
+// The score is k × log2(1 + d) × (1 + n), where k is the number of keys, d is the key diameter (see keyDiameter), and n is the greatest
+// number of doors between a start cell and any key, which approximates the depth of the dependencies between keys.
+// The number of keys dominates, since the number of states the solver has to visit grows exponentially with it.
+func (m *maze) Difficulty() float64 {
+	var doors int
+	for _, c := range m.start() {
+		for _, p := range c.paths {
+			if n := p.reqKeys.len(); n > doors {
+				doors = n
+			}
+		}
+	}
+	return float64(m.keys.len()) * math.Log2(1+float64(m.keyDiameter())) * float64(1+doors)
+}
+
+// checkPaths returns an error identifying the first path in m which leads to a different cell but has a length less than one.
+// The solver assumes that a move to another cell takes at least one step, so that the distances it adds up only grow along a route and
+// solveAStar's lower bound holds. A zero-length move which stays put, such as picking up a key removed by an anti-key, is allowed: states
+// from which the end can't be reached are memoized as noPath, so a memoized distance of zero means that the end is reached without walking
+// any further, never that a state has no moves.
+func (m *maze) checkPaths() error {
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c == nil {
+				continue
+			}
+			for _, p := range c.paths {
+				if p.dest != c && p.len < 1 {
+					return fmt.Errorf("path from %c at %d,%d to %c at %d,%d has length %d", c.char, c.row, c.col, p.dest.char, p.dest.row, p.dest.col, p.len)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkSelfLocking returns an error identifying the first key in m which is also its own door: that is, a key which can be reached, but only
+// by paths which pass through its own door, so it can never be collected. This usually means that the maze was generated incorrectly.
+func (m *maze) checkSelfLocking() error {
+	reached := make(map[*cell]bool) // whether each key can be reached by any path
+	open := make(map[*cell]bool)    // whether each key can be reached by a path which doesn't pass through its own door
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c == nil {
+				continue
+			}
+			for _, p := range c.paths {
+				if p.dest.cellType == key && p.dest != c {
+					reached[p.dest] = true
+					open[p.dest] = open[p.dest] || !p.reqKeys.contains(p.dest.char)
+				}
+			}
+		}
+	}
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil && reached[c] && !open[c] {
+				return fmt.Errorf("cell %c at %d,%d is both key and door: every path to it passes through door %c", c.char, c.row, c.col, c.char-'a'+'A')
+			}
+		}
+	}
+	return nil
+}
+
+// cell represents a (non-wall) cell in the maze.
+type cell struct {
+	char     byte
+	row, col int
+	adj      []*cell
+	paths    []path
+	cellType cellType
+	exit     direction      // the only direction in which a one-way cell can be exited, or anyDirection
+	hidden   map[*cell]byte // the key which reveals the passage to each cell which c is joined to by a hidden passage - see -reveal
+}
+
+// newCell returns a new cell with the value char and initialises its cellType.
+func newCell(char byte) *cell {
+	c := &cell{char: char}
+	switch {
+	case char == '@':
+		c.cellType = start
+	case 'a' <= char && char <= 'z':
+		c.cellType = key
+	case 'A' <= char && char <= 'Z':
+		c.cellType = door
+	}
+	return c
+}
+
+// copy returns a new, unconnected cell with the same character, type and exit direction as c.
+func (c *cell) copy() *cell {
+	return &cell{char: c.char, cellType: c.cellType, exit: c.exit}
+}
+
+// join adds c1 to c's adjacency list, and vice versa.
+func (c *cell) join(c1 *cell) {
+	c.link(c1)
+	c1.link(c)
+}
+
+// link adds c1 to c's adjacency list, but not vice versa, so that c1 can be reached from c but not the other way round.
+func (c *cell) link(c1 *cell) {
+	c.adj = append(c.adj, c1)
+}
+
+// hide marks the link from c to c1 as a hidden passage, which can only be followed once the key char has been collected.
+func (c *cell) hide(c1 *cell, char byte) {
+	if c.hidden == nil {
+		c.hidden = make(map[*cell]byte)
+	}
+	c.hidden[c1] = char
+}
+
+// linksTo returns true if c1 is in c's adjacency list, and false otherwise.
+func (c *cell) linksTo(c1 *cell) bool {
+	for _, adj := range c.adj {
+		if adj == c1 {
+			return true
+		}
+	}
+	return false
+}
+
+// connect joins c to its neighbour c1, which lies in direction d from c, omitting either side of the join that would exit a one-way cell in the wrong direction.
+func (c *cell) connect(c1 *cell, d direction) {
+	if c.exit == anyDirection || c.exit == d {
+		c.link(c1)
+	}
+	if c1.exit == anyDirection || c1.exit == d.opposite() {
+		c1.link(c)
+	}
+}
+
+// direction represents one of the compass directions in which a cell can be exited.
+// One-way cells can only be exited in one of the four orthogonal directions.
+type direction int
+
+const (
+	anyDirection direction = iota
+	north
+	west
+	south
+	east
+	northWest
+	northEast
+	southWest
+	southEast
+)
+
+// opposite returns the direction opposite to d.
+func (d direction) opposite() direction {
+	switch d {
+	case north:
+		return south
+	case west:
+		return east
+	case south:
+		return north
+	case east:
+		return west
+	case northWest:
+		return southEast
+	case northEast:
+		return southWest
+	case southWest:
+		return northEast
+	case southEast:
+		return northWest
+	}
+	return anyDirection
+}
+
+// oneWayExit returns the exit direction of the one-way passage represented by char, or anyDirection if char is not a one-way passage.
+func oneWayExit(char byte) direction {
+	switch char {
+	case '^':
+		return north
+	case '<':
+		return west
+	case 'v':
+		return south
+	case '>':
+		return east
+	}
+	return anyDirection
+}
+
+// cellType represents the type of a cell: empty, start, key or door.
+type cellType int
+
+const (
+	empty cellType = iota
+	start
+	key
+	door
+	wall // not the type of any cell, since walls have no cells, but used by maze.TypeGrid to represent them
+)
+
+// keyset represents a set of maze keys (lower-case ASCII characters) as a bitmap.
+type keyset uint
+
+// contains returns true if k contains c, and false otherwise.
+func (k keyset) contains(char byte) bool {
+	return k>>(char-'a')&1 == 1
+}
+
+// plus returns a new keyset which is the result of adding char to k.
+func (k keyset) plus(char byte) keyset {
+	return k | 1<<(char-'a')
+}
+
+// chars returns the characters in k in ascending order.
+func (k keyset) chars() []byte {
+	var chars []byte
+	for char := byte('a'); char <= 'z'; char++ {
+		if k.contains(char) {
+			chars = append(chars, char)
+		}
+	}
+	return chars
+}
+
+// String returns the characters in k in ascending order.
+func (k keyset) String() string {
+	return string(k.chars())
+}
+
+// len returns the number of keys in k.
+func (k keyset) len() int {
+	return bits.OnesCount(uint(k))
+}
+
+// containsAll returns true if keys is a subset of k, and false otherwise.
+func (k keyset) containsAll(keys keyset) bool {
+	return k&keys == keys
+}
+
+// path represents a path between two cells and includes the set of keys required to traverse it.
+type path struct {
+	len     int
+	dest    *cell
+	reqKeys keyset
+}
+
+// viable returns true if p can be followed by a robot holding keys: that is, if p leads to a key which is not in keys and only passes through doors which keys can open.
+func (p path) viable(keys keyset) bool {
+	return p.dest.cellType == key && !keys.contains(p.dest.char) && keys.containsAll(p.reqKeys)
+}
+
+// findPaths performs a breadth-first search of the cells reachable from c,
+// and returns a slice containing the shortest paths to all reachable keys and start cells.
+func findPaths(c *cell) []path {
+	return findPathsAvoiding(c, 0)
+}
+
+// findPathsAvoiding is like findPaths, but the paths don't pass through any door whose key is in closed.
+// If hidden passages can be reached from c, a shortcut through one doesn't replace the path which avoids it, since the passage's key may
+// not have been collected yet. Instead, the search is repeated with each subset of the passages' keys revealed, and every path is returned
+// which isn't beaten by another path to the same cell, one that is no longer and whose required keys are a subset of its own.
+func findPathsAvoiding(c *cell, closed keyset) []path {
+	var reveals keyset
+	bfsAvoiding(c, closedDoors(closed), func(current *cell, dist int) bool {
+		for _, char := range current.hidden {
+			reveals = reveals.plus(char)
+		}
+		return true
+	})
+	var paths []path
+	chars := reveals.chars()
+	for subset := 0; subset < 1<<len(chars); subset++ {
+		var revealed keyset
+		for i, char := range chars {
+			if subset&(1<<i) != 0 {
+				revealed = revealed.plus(char)
+			}
+		}
+		for _, p := range findRevealedPaths(c, closed, revealed) {
+			paths = addUnbeaten(paths, p)
+		}
+	}
+	return paths
+}
+
+// addUnbeaten returns paths with p added, unless one of them beats p, and with any which p beats removed.
+func addUnbeaten(paths []path, p path) []path {
+	for _, q := range paths {
+		if q.beats(p) {
+			return paths
+		}
+	}
+	var kept []path
+	for _, q := range paths {
+		if !p.beats(q) {
+			kept = append(kept, q)
+		}
+	}
+	return append(kept, p)
+}
+
+// beats returns true if p leads to the same cell as q, is no longer, and needs no keys which q doesn't, so q need never be followed instead.
+func (p path) beats(q path) bool {
+	return p.dest == q.dest && p.len <= q.len && q.reqKeys.containsAll(p.reqKeys)
+}
+
+// findDetours is like findPathsAvoiding, but as well as the shortest path to each key and start cell, it returns the longer paths which pass
+// through fewer doors, which may be worth following if opening doors is costly (see -door-cost): every path which isn't beaten by another.
+// They are found by closing each door on a path found so far in turn, and searching again, until closing doors finds no new paths.
+func findDetours(c *cell, closed keyset) []path {
+	var paths []path
+	tried := map[keyset]bool{closed: true}
+	for q := []keyset{closed}; len(q) > 0; q = q[1:] {
+		for _, p := range findPathsAvoiding(c, q[0]) {
+			paths = addUnbeaten(paths, p)
+			for _, char := range (p.reqKeys &^ q[0]).chars() {
+				if next := q[0].plus(char); !tried[next] {
+					tried[next] = true
+					q = append(q, next)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// findRevealedPaths is like findPathsAvoiding, but only follows the hidden passages whose keys are in revealed, and finds a single
+// shortest path to each cell.
+func findRevealedPaths(c *cell, closed, revealed keyset) []path {
+	var paths []path
+	reqKeys := map[*cell]keyset{c: 0}
+	avoid := passable(closed, revealed)
+	bfsAvoiding(c, avoid, func(current *cell, dist int) bool {
+
+		// If this cell is a key or a start cell, add the path to it to the list of paths to return.
+		if current.cellType == key || current.cellType == start {
+			paths = append(paths, path{len: dist, dest: current, reqKeys: reqKeys[current]})
+		}
+
+		// The shortest path to each newly reached neighbour passes through current, so it requires the same keys.
+		for _, adj := range current.adj {
+			if _, ok := reqKeys[adj]; ok || avoid(current, adj) {
+				continue
+			}
+			next := reqKeys[current]
+
+			// If adj is a door, then add its corresponding key to the path's required keys, and likewise for a hidden passage to adj.
+			if adj.cellType == door {
+				next = next.plus(adj.char | 32)
+			}
+			if char, ok := current.hidden[adj]; ok {
+				next = next.plus(char)
+			}
+			reqKeys[adj] = next
+		}
+		return true
+	})
+	return paths
+}
+
+// closedDoors returns a function which reports whether a step leads into a door whose key is in closed, for use with bfsAvoiding.
+func closedDoors(closed keyset) func(from, to *cell) bool {
+	return func(_, to *cell) bool { return to.cellType == door && closed.contains(to.char|32) }
+}
+
+// passable is like closedDoors, but the function it returns also reports true for a step along a hidden passage whose key isn't in revealed.
+func passable(closed, revealed keyset) func(from, to *cell) bool {
+	return func(from, to *cell) bool {
+		if char, ok := from.hidden[to]; ok && !revealed.contains(char) {
+			return true
+		}
+		return to.cellType == door && closed.contains(to.char|32)
+	}
+}
+
+// route returns the cells along the shortest path from c to dest which doesn't pass through any door whose key is in closed or isn't in
+// revealed, or along any hidden passage whose key isn't in revealed, including both ends, or nil if there is no such path. It follows the same
+// breadth-first search as findRevealedPaths, so given the reqKeys of one of the paths from c as revealed, the route passes through exactly the
+// doors in reqKeys, even if the path is a detour around other doors (see findDetours).
+func route(c, dest *cell, closed, revealed keyset) []*cell {
+	prev := map[*cell]*cell{c: nil}
+	var found bool
+	avoid := func(from, to *cell) bool {
+		return passable(closed, revealed)(from, to) || to.cellType == door && !revealed.contains(to.char|32)
+	}
+	bfsAvoiding(c, avoid, func(current *cell, dist int) bool {
+		if current == dest {
+			found = true
+			return false
+		}
+		for _, adj := range current.adj {
+			if _, ok := prev[adj]; !ok && !avoid(current, adj) {
+				prev[adj] = current
+			}
+		}
+		return true
+	})
+	if !found {
+		return nil
+	}
+	var cells []*cell
+	for current := dest; current != nil; current = prev[current] {
+		cells = append(cells, current)
+	}
+	for i, j := 0, len(cells)-1; i < j; i, j = i+1, j-1 {
+		cells[i], cells[j] = cells[j], cells[i]
+	}
+	return cells
+}
+
+// bfs performs a breadth-first search of the cells reachable from start, calling visit with each cell and its distance from start.
+// Cells are visited in order of increasing distance, and the search stops early if visit returns false.
+func bfs(start *cell, visit func(c *cell, dist int) bool) {
+	bfsAvoiding(start, nil, visit)
+}
+
+// bfsAvoiding is like bfs, but doesn't take any step from one cell to another for which avoid returns true. If avoid is nil, every step may be taken.
+func bfsAvoiding(start *cell, avoid func(from, to *cell) bool, visit func(c *cell, dist int) bool) {
+	type entry struct {
+		c    *cell
+		dist int
+	}
+	seen := map[*cell]bool{start: true}
+	for q := []entry{{start, 0}}; len(q) > 0; q = q[1:] {
+		current := q[0]
+		if !visit(current.c, current.dist) {
+			return
+		}
+		for _, adj := range current.c.adj {
+			if seen[adj] || avoid != nil && avoid(current.c, adj) {
+				continue
+			}
+			seen[adj] = true
+			q = append(q, entry{adj, current.dist + 1})
+		}
+	}
+}
+
+// solver holds the configuration and memoized results of a search for the shortest path through a maze.
+type solver struct {
+	m            *maze
+	order        searchOrder
+	table        map[string]int       // the length of the shortest path from each state visited so far to the end state
+	compactTable map[compactState]int // used instead of table if it is not nil - see -compress-state
+	segments     map[segment][]path   // the viable paths for each segment, if caching is enabled
+	detours      map[segment][]path   // the paths from each cell avoiding each set of opened doors, if keys are consumed - see pathsAvoiding
+	ctx          context.Context      // checked periodically by shortestPath, which abandons the search once ctx is done
+	calls        int
+	cancelled    bool
+	best         int    // the length of the shortest complete path found so far, or 0 if none has been found
+	deadlock     *state // the first state found from which no key can be collected, if any
+
+	deterministic bool       // whether to explore moves in order of the key they collect, so that the search is reproducible
+	rng           *rand.Rand // if not nil, used to shuffle the moves from each state and break ties between optimal routes - see -seed
+
+	goal       keyset  // the keys which must be collected: all of the keys in m, unless -goals is used
+	returnHome bool    // whether the robots must return to their start cells after collecting all of the keys
+	starts     []*cell // the start cell of each robot
+
+	expanded int // the number of states whose moves have been explored by shortestPath or solveAStar
+	branches int // the total number of moves available from those states
+
+	peakStates int // the largest number of states held by the memo table at any one time
+
+	tree      []treeEdge // the moves explored from states shallower than treeDepth, if treeDepth is not zero - see -tree
+	treeDepth int
+
+	doorCost   int             // the cost of opening each door, in addition to the steps walked - see advance
+	consume    bool            // whether opening a door uses up its key, so that each door can only be passed through once - see -consume-keys
+	removes    map[byte]keyset // the keys removed from the robots' keys by collecting each anti-key - see -anti-keys
+	assigned   map[int]keyset  // the keys which each robot may collect, by robot index, for robots which are restricted - see -assign
+	fuel       int             // the fuel each robot starts with and refuels to at each key, one unit per step, or 0 for unlimited - see -fuel
+	recall     int             // the cost of recalling a robot to its start cell, or 0 if robots can't be recalled - see -recall
+	maxRange   int             // the most steps a robot can walk between recharges at its start cell, or 0 for no limit - see inRange
+	before     map[byte]keyset // the keys which must be collected before each key, for keys which are constrained - see -collect-order
+	activation map[int]byte    // the key which must be collected before each robot can move, by robot index, for robots which start inactive
+
+	steps     []step                 // the moves of the shortest path found by solveAStar
+	distances map[*cell]map[byte]int // the distance from each robot position to each key, used by solveAStar's lower bound
+}
+
+// newSolver returns a new solver for m which explores moves in the given order.
+func newSolver(m *maze, order searchOrder) *solver {
+	return &solver{m: m, order: order, table: make(map[string]int), goal: m.keys}
+}
+
+// solve returns the length of the shortest path from s to the end state, or ctx's error if ctx is done before the search is complete.
+// If the search is abandoned, sv.best holds the length of the shortest complete path found so far, if any.
+func (sv *solver) solve(ctx context.Context, s state) (int, error) {
+	sv.ctx = ctx
+	sv.starts = s.cells
+	result := sv.shortestPath(s, 0)
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if result == noPath && sv.deadlock != nil {
+		d := sv.deadlock
+		if d.keys == 0 {
+			return 0, fmt.Errorf("%w: none of the keys %s can be reached from the start", errUnsolvable, sv.goal)
+		}
+		return 0, fmt.Errorf("%w: after collecting the keys %s, none of the keys %s can be reached", errUnsolvable, d.keys, sv.goal&^d.keys)
+	}
+	if result == noPath {
+		return 0, fmt.Errorf("%w: its keys can't all be collected", errUnsolvable)
+	}
+	return result, nil
+}
+
+// noPath is the length returned by shortestPath for a state from which the end state can't be reached.
+const noPath = -1
+
+// errUnsolvable is returned by solve if the end state can't be reached.
+var errUnsolvable = errors.New("maze cannot be solved")
+
+// shortestPath returns the length of the shortest path from s to the end state where we have collected all of the keys in sv.goal.
+// Partial results are memoized in sv.table, which massively reduces the number of recursive calls to shortestPath.
+// The walked parameter is the length of the path taken to reach s, which is used to keep track of the best complete path found so far.
+func (sv *solver) shortestPath(s state, walked int) int {
+	// If we've collected all the keys, we're done - unless the robots have to return to their start cells.
+	if s.keys.containsAll(sv.goal) {
+		d, ok := sv.finish(s)
+		if !ok {
+			return noPath
+		}
+		sv.complete(walked + d)
+		return d
+	}
+
+	// If the search has been cancelled, give up without memoizing anything. Checking the context is relatively expensive, so only do it occasionally.
+	if sv.calls++; sv.calls%4096 == 0 && sv.ctx != nil && sv.ctx.Err() != nil {
+		sv.cancelled = true
+	}
+	if sv.cancelled {
+		return 0
+	}
+
+	// If we've calculated this path before, return the memoized result.
+	if d, ok := sv.lookup(s); ok {
+		if d != noPath {
+			sv.complete(walked + d)
+		}
+		return d
+	}
+
+	// Calculate the total weight of each possible path from s. The result is the smallest such weight, or noPath if there are no paths to the end state.
+	min := noPath
+	moves := sv.moves(s)
+	sv.expanded++
+	sv.branches += len(moves)
+	for _, move := range moves {
+
+		// The total weight of this path is the length of the path, plus the length of the shortest path from the next state to the end state.
+		next, cost := sv.advance(s, move)
+		sv.recordEdge(s, next, move)
+		rest := sv.shortestPath(next, walked+cost)
+		if rest == noPath {
+			continue
+		}
+		if dist := cost + rest; min == noPath || dist < min {
+			min = dist
+		}
+	}
+
+	// If there are no moves at all from s, remember it so that we can explain why the maze can't be solved.
+	if len(moves) == 0 && sv.deadlock == nil {
+		sv.deadlock = &s
+	}
+
+	// Memoize the result so we don't have to calculate it again - unless the search was cancelled, in which case it may be wrong.
+	if sv.cancelled {
+		return 0
+	}
+	sv.memoize(s, min)
+	return min
+}
+
+// solveGoals finds the length of the shortest path from s which collects each of the sets of keys listed in goals, which are separated by
+// semicolons, and prints a line of the form "keys: length" for each, or "keys: unsolvable" if they can't be collected. The memoized
+// distances depend on the goal, so they are discarded between goals, but the paths between keys are shared.
+func (sv *solver) solveGoals(ctx context.Context, s state, goals string) error {
+	names := strings.Split(goals, ";")
+	targets := make([]keyset, len(names))
+	for i, goal := range names {
+		if goal == "" {
+			return fmt.Errorf("invalid goals %q: goal %d is empty", goals, i+1)
+		}
+		for j := 0; j < len(goal); j++ {
+			if !sv.m.keys.contains(goal[j]) {
+				return fmt.Errorf("invalid goal %q: %q is not a key in the maze", goal, goal[j])
+			}
+			targets[i] = targets[i].plus(goal[j])
+		}
+	}
+	for i, goal := range names {
+		sv.goal, sv.best, sv.deadlock, sv.cancelled = targets[i], 0, nil, false
+		sv.table = make(map[string]int)
+		if sv.compactTable != nil {
+			sv.compactTable = make(map[compactState]int)
+		}
+		result, err := sv.solve(ctx, s)
+		switch {
+		case errors.Is(err, errUnsolvable):
+			fmt.Printf("%s: unsolvable\n", goal)
+		case err != nil:
+			return fmt.Errorf("goal %s: %w", goal, err)
+		default:
+			fmt.Printf("%s: %s\n", goal, formatAnswer(result))
+		}
+	}
+	return nil
+}
+
+// branchingFactor returns the average number of moves available from each state explored by the search, or 0 if none has been explored.
+func (sv *solver) branchingFactor() float64 {
+	if sv.expanded == 0 {
+		return 0
+	}
+	return float64(sv.branches) / float64(sv.expanded)
+}
+
+// histogram returns the number of memoized states with each distance to the end state, indexed by distance.
+// States from which the end state can't be reached are counted under noPath.
+func (sv *solver) histogram() map[int]int {
+	counts := make(map[int]int)
+	for _, d := range sv.table {
+		counts[d]++
+	}
+	for _, d := range sv.compactTable {
+		counts[d]++
+	}
+	return counts
+}
+
+// printHistogram writes a line to w for each distance memoized by sv, in ascending order, of the form "distance count".
+func (sv *solver) printHistogram(w io.Writer) {
+	counts := sv.histogram()
+	distances := make([]int, 0, len(counts))
+	for d := range counts {
+		distances = append(distances, d)
+	}
+	sort.Ints(distances)
+	for _, d := range distances {
+		fmt.Fprintf(w, "%d %d\n", d, counts[d])
+	}
+}
+
+// lookup returns the memoized length of the shortest path from s to the end state, and false if it hasn't been memoized.
+func (sv *solver) lookup(s state) (int, bool) {
+	if sv.compactTable != nil {
+		d, ok := sv.compactTable[s.compact()]
+		return d, ok
+	}
+	d, ok := sv.table[s.String()]
+	return d, ok
+}
+
+// memoize records d as the length of the shortest path from s to the end state.
+func (sv *solver) memoize(s state, d int) {
+	if sv.compactTable != nil {
+		sv.compactTable[s.compact()] = d
+		sv.peakStates = max(sv.peakStates, len(sv.compactTable))
+		return
+	}
+	sv.table[s.String()] = d
+	sv.peakStates = max(sv.peakStates, len(sv.table))
+}
+
+// states returns the number of states memoized by sv.
+func (sv *solver) states() int {
+	if sv.compactTable != nil {
+		return len(sv.compactTable)
+	}
+	return len(sv.table)
+}
+
+// finish returns the distance from the end state s to the true end of the traversal: the sum of the distances from each robot back to its start cell
+// if sv.returnHome is set, or zero otherwise. The second return value is false if some robot cannot get back to its start cell.
+func (sv *solver) finish(s state) (int, bool) {
+	var total int
+	opened := s.opened
+	for _, move := range sv.returnSteps(s) {
+		if move.path.dest == nil {
+			return 0, false
 		}
-		r = f
+		if move.recall {
+			total += move.path.len
+			continue
+		}
+		total += sv.pathCost(move.path, opened)
+		opened |= move.path.reqKeys
 	}
-	m := readMaze(r)
-	initial := state{cells: m.start(), keys: 0}
-	result := shortestPath(m, initial, make(map[string]int))
-	fmt.Printf("%d\n", result)
+	return total, true
 }
 
-// maze represents the maze.
-type maze struct {
-	w, h int
-	rows [][]*cell
-	keys keyset
+// pathCost returns the cost of following p once the doors in opened have been opened: its length, plus sv.doorCost for each other door on it.
+func (sv *solver) pathCost(p path, opened keyset) int {
+	return p.len + sv.doorCost*(p.reqKeys&^opened).len()
 }
 
-// readMaze reads a maze from r and returns it. The input is assumed to be a rectangular grid of characters.
-func readMaze(r io.Reader) *maze {
-	var rows []string
-	for scanner := bufio.NewScanner(r); scanner.Scan(); {
-		rows = append(rows, scanner.Text())
+// returnSteps returns the moves which take each robot in s back to its start cell, if sv.returnHome is set.
+// Robots which are already at their start cell don't move, and the move of a robot which can't reach its start cell has a nil destination.
+// A robot is recalled rather than walking back if sv.recall is set and the recall costs less.
+func (sv *solver) returnSteps(s state) []step {
+	if !sv.returnHome {
+		return nil
 	}
-	m := newMaze(len(rows[0]), len(rows))
-	for i := range rows {
-		for j := range rows[i] {
-			if char := rows[i][j]; char != '#' {
-				m.addCell(i, j, newCell(char))
+	var moves []step
+	for i, c := range s.cells {
+		if c == sv.starts[i] {
+			continue
+		}
+		move := step{robot: i, closed: sv.closed(s)}
+		// There may be more than one path back if the maze has hidden passages or doors are costly, so take the cheapest which the keys held allow.
+		for _, p := range sv.pathsAvoiding(c, s.opened) {
+			if p.dest != sv.starts[i] || !s.keys.containsAll(p.reqKeys) || sv.fuel > 0 && p.len > sv.fuel {
+				continue
+			}
+			if move.path.dest == nil || sv.pathCost(p, s.opened) < sv.pathCost(move.path, s.opened) {
+				move.path = p
 			}
 		}
+		if sv.recall > 0 && (move.path.dest == nil || sv.recall < move.path.len) {
+			move = step{robot: i, path: path{dest: sv.starts[i], len: sv.recall}, recall: true}
+		}
+		moves = append(moves, move)
 	}
-	m.buildPaths()
-	return m
+	return moves
 }
 
-// newMaze initialises a new maze with width w and height h.
-func newMaze(w, h int) *maze {
-	cells := make([]*cell, w*h)
-	rows := make([][]*cell, h)
-	for i := range rows {
-		rows[i] = cells[i*w : (i+1)*w]
+// collectWithin returns the moves which collect as many keys as possible from s without walking more than budget steps, along with the total
+// distance walked. If several routes collect the same number of keys, the shortest is chosen. Recall moves don't collect a key, so they don't count.
+func (sv *solver) collectWithin(s state, budget int) (int, []step) {
+	var bestWalked, bestCollected int
+	var best []step
+	reached := make(map[string]int) // the shortest distance walked to reach each state visited so far
+	var visit func(s state, walked, collected int, moves []step)
+	visit = func(s state, walked, collected int, moves []step) {
+		if collected > bestCollected || collected == bestCollected && walked < bestWalked {
+			bestWalked, bestCollected, best = walked, collected, append([]step(nil), moves...)
+		}
+
+		// If we've already reached this state by a route at least as short, there's nothing more to find from here.
+		stateKey := s.String()
+		if d, ok := reached[stateKey]; ok && d <= walked {
+			return
+		}
+		reached[stateKey] = walked
+		for _, move := range sv.moves(s) {
+			next, cost := sv.advance(s, move)
+			if walked+cost > budget {
+				continue
+			}
+			if move.recall {
+				visit(next, walked+cost, collected, append(moves, move))
+			} else {
+				visit(next, walked+cost, collected+1, append(moves, move))
+			}
+		}
 	}
-	return &maze{w, h, rows, 0}
+	visit(s, 0, 0, nil)
+	return bestWalked, best
 }
 
-// addCell adds c to m at row i and column j, joining c to any neighbours and, if c is a key, adds its value to m's keyset.
-func (m *maze) addCell(i, j int, c *cell) {
-	m.rows[i][j] = c
-	if i > 0 && m.rows[i-1][j] != nil {
-		c.join(m.rows[i-1][j])
+// firstSolution returns the length of the first complete route found by a depth-first search from s which always tries the cheapest move first,
+// along with its moves, and false if the keys in sv.goal can't all be collected. The route is usually short, but isn't necessarily the shortest.
+func (sv *solver) firstSolution(s state) (int, []step, bool) {
+	failed := make(map[string]bool) // the states from which the end state is known to be unreachable
+	var moves []step
+	var visit func(s state, walked int) (int, bool)
+	visit = func(s state, walked int) (int, bool) {
+		if s.keys.containsAll(sv.goal) {
+			d, ok := sv.finish(s)
+			return walked + d, ok
+		}
+		stateKey := s.String()
+		if failed[stateKey] {
+			return 0, false
+		}
+		type candidate struct {
+			move step
+			next state
+			cost int
+		}
+		var candidates []candidate
+		for _, move := range sv.moves(s) {
+			next, cost := sv.advance(s, move)
+			candidates = append(candidates, candidate{move, next, cost})
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].cost < candidates[j].cost })
+		for _, c := range candidates {
+			moves = append(moves, c.move)
+			if total, ok := visit(c.next, walked+c.cost); ok {
+				return total, true
+			}
+			moves = moves[:len(moves)-1]
+		}
+		failed[stateKey] = true
+		return 0, false
 	}
-	if j > 0 && m.rows[i][j-1] != nil {
-		c.join(m.rows[i][j-1])
+	total, ok := visit(s, 0)
+	return total, moves, ok
+}
+
+// dumpTable writes each entry in sv.table to the file with the given name, one per line in the form "key distance", sorted by key.
+func (sv *solver) dumpTable(name string) error {
+	table := sv.table
+	if sv.compactTable != nil {
+		table = make(map[string]int, len(sv.compactTable))
+		for cs, d := range sv.compactTable {
+			table[cs.String()] = d
+		}
 	}
-	if i+1 < m.h && m.rows[i+1][j] != nil {
-		c.join(m.rows[i+1][j])
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
 	}
-	if j+1 < m.w && m.rows[i][j+1] != nil {
-		c.join(m.rows[i][j+1])
+	sort.Strings(keys)
+	f, err := os.Create(name)
+	if err != nil {
+		return err
 	}
-	if c.cellType == key {
-		m.keys = m.keys.plus(c.char)
+	w := bufio.NewWriter(f)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s %d\n", k, table[k])
 	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-// start returns a slice containing all start cells in m.
-func (m *maze) start() []*cell {
-	var startCells []*cell
-	for i := range m.rows {
-		for _, c := range m.rows[i] {
-			if c != nil && c.cellType == start {
-				startCells = append(startCells, c)
+// complete records that a complete path of length n has been found.
+func (sv *solver) complete(n int) {
+	if sv.best == 0 || n < sv.best {
+		sv.best = n
+	}
+}
+
+// advance returns the state which results from making move from s, in which any keys removed by the key collected (see -anti-keys)
+// are no longer held, and the cost of the move: the length of its path, plus sv.doorCost
+// for each door on the path which hasn't been opened before. If sv.doorCost is zero and keys aren't consumed, the doors opened aren't
+// tracked, so that states which differ only in the doors opened are memoized together.
+func (sv *solver) advance(s state, move step) (state, int) {
+	next := s.next(move)
+	next.keys &^= sv.removes[move.path.dest.char]
+	if sv.doorCost == 0 && !sv.consume {
+		return next, move.path.len
+	}
+	next.opened |= move.path.reqKeys
+	return next, sv.pathCost(move.path, s.opened)
+}
+
+// moves returns the moves which can be made from s, in the order given by sv.order. Robots which are not yet active can't move, and robots
+// which have been assigned a set of keys can only collect keys in that set, and keys can only be collected once the keys which must be
+// collected before them have been. If sv.fuel is set, no move can be longer than that. If sv.recall is set, each active robot which isn't at its start
+// cell can also be recalled there.
+// If sv.deterministic is set, moves which are equal under sv.order are sorted by the key they collect, rather than by robot and distance.
+// Otherwise, if sv.rng is set, moves which are equal under sv.order are in random order.
+func (sv *solver) moves(s state) []step {
+	var moves []step
+	for i, cell := range s.cells {
+		if char, ok := sv.activation[i]; ok && !s.keys.contains(char) {
+			continue
+		}
+		allowed, restricted := sv.assigned[i]
+		for _, path := range sv.viablePaths(cell, s.keys, s.opened) {
+			if restricted && !allowed.contains(path.dest.char) || !s.keys.containsAll(sv.before[path.dest.char]) {
+				continue
+			}
+			if sv.fuel > 0 && path.len > sv.fuel {
+				continue
+			}
+			if sv.maxRange > 0 {
+				var ok bool
+				if path, ok = sv.inRange(i, cell, path, s.keys); !ok {
+					continue
+				}
 			}
+			moves = append(moves, step{robot: i, path: path, closed: sv.closed(s)})
+		}
+		if sv.recall > 0 && cell != sv.starts[i] {
+			moves = append(moves, step{robot: i, path: path{dest: sv.starts[i], len: sv.recall}, recall: true})
 		}
 	}
-	return startCells
+	if sv.rng != nil {
+		sv.rng.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+	}
+	if sv.deterministic {
+		sort.SliceStable(moves, func(i, j int) bool { return moves[i].path.dest.char < moves[j].path.dest.char })
+	}
+	switch sv.order {
+	case nearestFirst:
+		sort.SliceStable(moves, func(i, j int) bool { return moves[i].path.len < moves[j].path.len })
+	case farthestFirst:
+		sort.SliceStable(moves, func(i, j int) bool { return moves[i].path.len > moves[j].path.len })
+	}
+	return moves
 }
 
-// buildPaths populates the path list for each start and key cell in m.
-func (m *maze) buildPaths() {
-	for i := range m.rows {
-		for _, c := range m.rows[i] {
-			if c != nil && (c.cellType == key || c.cellType == start) {
-				c.paths = findPaths(c)
+// inRange returns the path which robot i should follow to reach the destination of p, which starts at the robot's current position c, without
+// walking more than sv.maxRange steps between recharges at its start cell. That is p itself if it is short enough, or else a path which goes
+// back to the robot's start cell and then on to the destination, if both legs are short enough and the robot holds the keys for both.
+// The second return value is false if there is no such path.
+func (sv *solver) inRange(i int, c *cell, p path, keys keyset) (path, bool) {
+	if p.len <= sv.maxRange {
+		return p, true
+	}
+	home := sv.starts[i]
+	var toHome, fromHome path
+	// There may be more than one path between two cells if the maze has hidden passages, so take the shortest which keys allow.
+	for _, q := range c.paths {
+		if q.dest == home && keys.containsAll(q.reqKeys) && (toHome.dest == nil || q.len < toHome.len) {
+			toHome = q
+		}
+	}
+	for _, q := range home.paths {
+		if q.dest == p.dest && keys.containsAll(q.reqKeys) && (fromHome.dest == nil || q.len < fromHome.len) {
+			fromHome = q
+		}
+	}
+	if toHome.dest == nil || fromHome.dest == nil || toHome.len > sv.maxRange || fromHome.len > sv.maxRange {
+		return path{}, false
+	}
+	if !keys.containsAll(toHome.reqKeys | fromHome.reqKeys) {
+		return path{}, false
+	}
+	return path{len: toHome.len + fromHome.len, dest: p.dest, reqKeys: toHome.reqKeys | fromHome.reqKeys}, true
+}
+
+// segment identifies a robot's position and the keys collected so far, which together determine the paths that the robot can follow.
+type segment struct {
+	c    *cell
+	keys keyset
+}
+
+// viablePaths returns the paths from c which can be followed by a robot holding keys, once the doors in opened have been opened.
+// If sv.segments is not nil, the result is cached in it, so that the paths from each cell are only filtered once for each keyset.
+// This only helps when there are several robots, since otherwise each segment is only visited once, and even then the cost of the cache lookup
+// usually outweighs the cost of filtering the paths, so caching is disabled by default. The opened doors only matter if sv.consume is set,
+// in which case the paths avoid them (see pathsAvoiding). Those paths, and the detours followed if sv.doorCost is set, aren't cached in sv.segments.
+func (sv *solver) viablePaths(c *cell, keys, opened keyset) []path {
+	if sv.doorCost > 0 || sv.consume && opened != 0 {
+		var paths []path
+		for _, p := range sv.pathsAvoiding(c, opened) {
+			if p.viable(keys) {
+				paths = append(paths, p)
 			}
 		}
+		return paths
+	}
+	seg := segment{c, keys}
+	if paths, ok := sv.segments[seg]; ok {
+		return paths
+	}
+	var paths []path
+	for _, p := range c.paths {
+		if p.viable(keys) {
+			paths = append(paths, p)
+		}
+	}
+	if sv.segments != nil {
+		sv.segments[seg] = paths
 	}
+	return paths
 }
 
-// cell represents a (non-wall) cell in the maze.
-type cell struct {
-	char     byte
-	adj      []*cell
-	paths    []path
-	cellType cellType
+// closed returns the doors which can't be passed through from s: those which have been opened, if sv.consume is set, or none otherwise.
+func (sv *solver) closed(s state) keyset {
+	if !sv.consume {
+		return 0
+	}
+	return s.opened
 }
 
-// newCell returns a new cell with the value char and initialises its cellType.
-func newCell(char byte) *cell {
-	c := &cell{char: char}
-	switch {
-	case char == '@':
-		c.cellType = start
-	case 'a' <= char && char <= 'z':
-		c.cellType = key
-	case 'A' <= char && char <= 'Z':
-		c.cellType = door
+// pathsAvoiding returns the shortest paths from c to each key and start cell which don't pass through any of the doors in opened, if
+// sv.consume is set, since those doors' keys have been used up. If sv.doorCost is set, it also returns the detours around doors found by
+// findDetours. Otherwise, it returns c.paths. The paths avoiding each set of doors are found when they are first needed, and cached in sv.detours.
+func (sv *solver) pathsAvoiding(c *cell, opened keyset) []path {
+	closed := sv.closed(state{opened: opened})
+	if sv.doorCost == 0 && closed == 0 {
+		return c.paths
 	}
-	return c
+	seg := segment{c, closed}
+	if paths, ok := sv.detours[seg]; ok {
+		return paths
+	}
+	if sv.detours == nil {
+		sv.detours = make(map[segment][]path)
+	}
+	var paths []path
+	if sv.doorCost > 0 {
+		paths = findDetours(c, closed)
+	} else {
+		paths = findPathsAvoiding(c, closed)
+	}
+	sv.detours[seg] = paths
+	return paths
 }
 
-// join adds c1 to c's adjacency list, and vice versa.
-func (c *cell) join(c1 *cell) {
-	c.adj = append(c.adj, c1)
-	c1.adj = append(c1.adj, c)
+// frontier returns the keys which can be collected in a single move from s, mapped to the distance to each from the nearest robot.
+func (m *maze) frontier(s state) map[byte]int {
+	keys := make(map[byte]int)
+	for _, c := range s.cells {
+		for _, p := range c.paths {
+			if !p.viable(s.keys) {
+				continue
+			}
+			if d, ok := keys[p.dest.char]; !ok || p.len < d {
+				keys[p.dest.char] = p.len
+			}
+		}
+	}
+	return keys
 }
 
-// cellType represents the type of a cell: empty, start, key or door.
-type cellType int
+// searchOrder determines the order in which the solver explores the moves available from each state.
+type searchOrder int
 
 const (
-	empty cellType = iota
-	start
-	key
-	door
+	indexOrder    searchOrder = iota // by robot index, then by distance from the robot
+	nearestFirst                     // by ascending path length
+	farthestFirst                    // by descending path length
 )
 
-// keyset represents a set of maze keys (lower-case ASCII characters) as a bitmap.
-type keyset uint
+// String returns the name of o.
+func (o searchOrder) String() string {
+	switch o {
+	case nearestFirst:
+		return "nearest"
+	case farthestFirst:
+		return "far"
+	}
+	return "index"
+}
 
-// contains returns true if k contains c, and false otherwise.
-func (k keyset) contains(char byte) bool {
-	return k>>(char-'a')&1 == 1
+// Set sets o to the searchOrder named by name, which is one of "index", "nearest" or "far".
+func (o *searchOrder) Set(name string) error {
+	switch name {
+	case "index":
+		*o = indexOrder
+	case "nearest":
+		*o = nearestFirst
+	case "far":
+		*o = farthestFirst
+	default:
+		return fmt.Errorf("unknown search order %q", name)
+	}
+	return nil
 }
 
-// plus returns a new keyset which is the result of adding char to k.
-func (k keyset) plus(char byte) keyset {
-	return k | 1<<(char-'a')
+// state represents the current state of a maze traversal, including the list of current positions and the set of collected keys.
+type state struct {
+	cells  []*cell
+	keys   keyset
+	opened keyset // the doors which have been opened, tracked only if opening a door has a cost or consumes its key - see solver.advance
 }
 
-// containsAll returns true if keys is a subset of k, and false otherwise.
-func (k keyset) containsAll(keys keyset) bool {
-	return k&keys == keys
+// maxCompactRobots is the greatest number of robots whose state can be represented by a compactState.
+const maxCompactRobots = 8
+
+// compactState is a fixed-size, comparable representation of a state, which is cheaper to build and hash than its string representation.
+// Like the string representation, it identifies each robot's position by the character under it, which is unique for keys, and for
+// start cells, since a robot only ever stands on its own start cell. Unused positions are zero.
+type compactState struct {
+	cells  [maxCompactRobots]byte
+	keys   keyset
+	opened keyset
 }
 
-// path represents a path between two cells and includes the set of keys required to traverse it.
-type path struct {
-	len     int
-	dest    *cell
-	reqKeys keyset
+// compact returns the compactState representing s, which must have no more than maxCompactRobots robots.
+func (s state) compact() compactState {
+	var cs compactState
+	for i, c := range s.cells {
+		cs.cells[i] = c.char
+	}
+	cs.keys, cs.opened = s.keys, s.opened
+	return cs
 }
 
-// findPaths performs a breadth-first search of the cells reachable from c,
-// and returns a slice containing the shortest paths to all reachable keys.
-func findPaths(c *cell) []path {
-	var paths []path
-	start := path{len: 0, dest: c}
-	seen := map[*cell]bool{c: true}
-	for q := []path{start}; len(q) > 0; q = q[1:] {
-		current := q[0]
+// String returns the same representation as the String method of the state which cs represents.
+func (cs compactState) String() string {
+	n := bytes.IndexByte(cs.cells[:], 0)
+	if n < 0 {
+		n = len(cs.cells)
+	}
+	return state{keys: cs.keys, opened: cs.opened}.format(cs.cells[:n])
+}
 
-		// If this path ends at a key, add it to the list of paths to return.
-		if current.dest.cellType == key {
-			paths = append(paths, current)
-		}
-		for _, adj := range current.dest.adj {
-			if seen[adj] {
-				continue
-			}
-			seen[adj] = true
-			next := path{dest: adj, len: current.len + 1, reqKeys: current.reqKeys}
+// String returns a unique string representation of s. Used as a map key for memoization.
+func (s state) String() string {
+	cells := make([]byte, len(s.cells))
+	for i := range cells {
+		cells[i] = s.cells[i].char
+	}
+	return s.format(cells)
+}
 
-			// If adj is a door, then add its corresponding key to the path's required keys.
-			if adj.cellType == door {
-				next.reqKeys = next.reqKeys.plus(adj.char | 32)
-			}
-			q = append(q, next)
+// format returns the string representation of s, given the character under each robot. The doors opened are only included if there are any.
+func (s state) format(cells []byte) string {
+	if s.opened != 0 {
+		return fmt.Sprintf("%s%d/%d", cells, s.keys, s.opened)
+	}
+	return fmt.Sprintf("%s%d", cells, s.keys)
+}
+
+// next returns the state which results from making move from s: the moving robot's cell is replaced by the path's destination, whose key is collected.
+func (s state) next(move step) state {
+	nextState := s.copy()
+	nextState.cells[move.robot] = move.path.dest
+	if move.path.dest.cellType == key {
+		nextState.keys = s.keys.plus(move.path.dest.char)
+	}
+	return nextState
+}
+
+// copy returns a copy of s.
+func (s state) copy() state {
+	newState := state{cells: make([]*cell, len(s.cells)), keys: s.keys, opened: s.opened}
+	copy(newState.cells, s.cells)
+	return newState
+}
+
+// step represents a single move in a traversal: the index of the robot which moved, and the path it followed.
+type step struct {
+	robot  int
+	path   path
+	recall bool   // whether the robot was recalled to its start cell, rather than walking there along path - see -recall
+	closed keyset // the doors which path avoids, because their keys have been used up - see -consume-keys
+}
+
+// cells returns the cells which the robot passes through on st, starting from s, including both ends. A recall passes through no cells in
+// between, since the robot is returned to its start cell instantly.
+func (st step) cells(s state) []*cell {
+	if st.recall {
+		return []*cell{s.cells[st.robot], st.path.dest}
+	}
+	return route(s.cells[st.robot], st.path.dest, st.closed, st.path.reqKeys)
+}
+
+// trace reconstructs the moves which make up the shortest path from s to the end state, using the results memoized by shortestPath,
+// or returns the path found by solveAStar.
+func (sv *solver) trace(s state) []step {
+	if sv.steps != nil {
+		return sv.steps
+	}
+	var steps []step
+	for !s.keys.containsAll(sv.goal) {
+		remaining, _ := sv.lookup(s)
+		next, move, ok := sv.nextStep(s, remaining)
+		if !ok {
+			break
 		}
+		steps = append(steps, move)
+		s = next
 	}
-	return paths
+	if s.keys.containsAll(sv.goal) {
+		steps = append(steps, sv.returnSteps(s)...)
+	}
+	return steps
 }
 
-// shortestPath returns the length of the shortest path from s to the end state where we have collected all of the keys in m.
-// The table parameter massively reduces the number of recursive calls to shortestPath by memoizing partial results - pass an empty map.
-func shortestPath(m *maze, s state, table map[string]int) int {
-	stateKey := s.String()
+// nextStep finds a move from s which lies on a shortest path of length remaining, and returns the resulting state and the move itself.
+// If there are several such moves, the one which collects the alphabetically first key is chosen, so that the trace doesn't depend on the search order,
+// unless sv.rng is set, in which case the first in the shuffled order returned by moves is chosen, so that different seeds give different traces.
+// The final return value is false if there is no such move.
+func (sv *solver) nextStep(s state, remaining int) (state, step, bool) {
+	var best step
+	var found bool
+	for _, move := range sv.moves(s) {
+		nextState, cost := sv.advance(s, move)
 
-	// If we've collected all the keys, we're done.
-	if s.keys == m.keys {
-		return 0
+		// Terminal states aren't memoized, since the remaining distance from them is easily calculated.
+		dist, ok := sv.lookup(nextState)
+		if nextState.keys.containsAll(sv.goal) {
+			dist, ok = sv.finish(nextState)
+		}
+		if !ok || dist == noPath || cost+dist != remaining {
+			continue
+		}
+		if !found || sv.rng == nil && (move.path.dest.char < best.path.dest.char || move.path.dest.char == best.path.dest.char && move.robot < best.robot) {
+			best, found = move, true
+		}
 	}
+	if !found {
+		return s, step{}, false
+	}
+	next, _ := sv.advance(s, best)
+	return next, best, true
+}
 
-	// If we've calculated this path before, return the memoized result.
-	if d, ok := table[stateKey]; ok {
-		return d
+// printTrace writes a line to w for each move in steps, starting from s, followed by the total number of steps walked by each robot
+// and the doors opened along the way. If bits is set, each line also shows the keys collected so far as a hexadecimal bitmap.
+func printTrace(w io.Writer, s state, steps []step, bits bool) {
+	walked := make([]int, len(s.cells))
+	keys := s.keys
+	for _, st := range steps {
+		walked[st.robot] += st.path.len
+		var suffix string
+		if st.path.dest.cellType == key {
+			keys = keys.plus(st.path.dest.char)
+		}
+		if bits {
+			suffix = fmt.Sprintf(" (keys %#x)", uint(keys))
+		}
+		if st.recall {
+			fmt.Fprintf(w, "robot %d: recalled to start for %d%s\n", st.robot+1, st.path.len, suffix)
+			continue
+		}
+		if st.path.dest.cellType == start {
+			fmt.Fprintf(w, "robot %d: walked %d back to start%s\n", st.robot+1, st.path.len, suffix)
+			continue
+		}
+		if st.path.len == 0 {
+			// Only an anti-key can make a robot collect the key it is standing on (see -anti-keys).
+			fmt.Fprintf(w, "robot %d: picked up key %c again%s\n", st.robot+1, st.path.dest.char, suffix)
+			continue
+		}
+		fmt.Fprintf(w, "robot %d: walked %d to key %c%s\n", st.robot+1, st.path.len, st.path.dest.char, suffix)
+	}
+	for i, n := range walked {
+		fmt.Fprintf(w, "robot %d: %d steps\n", i+1, n)
 	}
+	if doors := doorsOpened(s, steps); len(doors) > 0 {
+		fmt.Fprintf(w, "doors opened: %s\n", strings.Join(strings.Split(string(doors), ""), ", "))
+		unlocked := unlockSteps(steps)
+		for _, door := range doors {
+			fmt.Fprintf(w, "door %c: %d\n", door, unlocked[door|32])
+		}
+	}
+}
 
-	// Calculate the total weight of each possible path from s. The result is the smallest such weight.
-	var min int
-	for i, cell := range s.cells {
-		for _, path := range cell.paths {
+// unlockSteps returns the total number of steps walked by all of the robots, in the order of the moves in steps, when each key is first
+// collected. That is the earliest step at which the key's doors can be opened along the route, even if the key is later removed by an
+// anti-key and collected again (see -anti-keys). Recalls count as the steps they cost.
+func unlockSteps(steps []step) map[byte]int {
+	unlocked := make(map[byte]int)
+	var total int
+	for _, st := range steps {
+		total += st.path.len
+		if c := st.path.dest; c.cellType == key {
+			if _, ok := unlocked[c.char]; !ok {
+				unlocked[c.char] = total
+			}
+		}
+	}
+	return unlocked
+}
 
-			// If this path leads to a key we've already collected, or if it passes through a door we can't open, ignore it.
-			if s.keys.contains(path.dest.char) || !s.keys.containsAll(path.reqKeys) {
-				continue
+// doorsOpened returns the doors passed through by the moves in steps, starting from s, in the order in which they are first opened.
+func doorsOpened(s state, steps []step) []byte {
+	var doors []byte
+	var opened keyset
+	for _, st := range steps {
+		for _, c := range st.cells(s) {
+			if c.cellType == door && !opened.contains(c.char|32) {
+				opened = opened.plus(c.char | 32)
+				doors = append(doors, c.char)
 			}
+		}
+		s = s.next(st)
+	}
+	return doors
+}
 
-			// Create the next state as a copy of the current state, replacing the current cell with the new cell and adding the new cell's key.
-			nextState := s.copy()
-			nextState.cells[i] = path.dest
-			nextState.keys = s.keys.plus(path.dest.char)
+// routeCells returns the number of distinct cells visited by the moves in steps, starting from s, including the robots' start cells.
+// Cells which are passed through more than once, where a route doubles back on itself or crosses another robot's route, are only counted once.
+func routeCells(s state, steps []step) int {
+	visited := make(map[*cell]bool)
+	for _, c := range s.cells {
+		visited[c] = true
+	}
+	for _, st := range steps {
+		for _, c := range st.cells(s) {
+			visited[c] = true
+		}
+		s = s.next(st)
+	}
+	return len(visited)
+}
 
-			// The total weight of this path is the length of the path, plus the length of the shortest path from the next state to the end state.
-			dist := path.len + shortestPath(m, nextState, table)
-			if min == 0 || dist < min {
-				min = dist
+// printExplanation writes a sentence to w for each move in steps, starting from s, describing which robot moved, how far it walked, and which
+// doors it opened on the way. Only the doors on the robot's route which no robot has opened before are mentioned, as in doorsOpened.
+func printExplanation(w io.Writer, s state, steps []step) {
+	collectedBy := make(map[byte]int)
+	var opened keyset
+	for _, st := range steps {
+		var doors []byte
+		for _, c := range st.cells(s) {
+			if c.cellType == door && !opened.contains(c.char|32) {
+				opened = opened.plus(c.char | 32)
+				doors = append(doors, c.char)
+			}
+		}
+		s = s.next(st)
+		if st.recall {
+			fmt.Fprintf(w, "Robot %d was recalled to its start for %d.\n", st.robot+1, st.path.len)
+			continue
+		}
+		if st.path.dest.cellType == start {
+			fmt.Fprintf(w, "Robot %d walked %d back to its start.\n", st.robot+1, st.path.len)
+			continue
+		}
+		fmt.Fprintf(w, "Robot %d walked %d to key %c", st.robot+1, st.path.len, st.path.dest.char)
+		if len(doors) == 0 {
+			fmt.Fprint(w, " (no doors)")
+		}
+		for i, char := range doors {
+			if i == 0 {
+				fmt.Fprint(w, ", opening")
+			} else {
+				fmt.Fprint(w, ",")
 			}
+			fmt.Fprintf(w, " door %c using key %c (collected by robot %d)", char, char|32, collectedBy[char|32]+1)
 		}
+		fmt.Fprintln(w, ".")
+		collectedBy[st.path.dest.char] = st.robot
 	}
+}
 
-	// Memoize the result so we don't have to calculate it again.
-	table[stateKey] = min
-	return min
+// keyPartition returns the keys collected by each robot in steps, in the order they are collected, keyed by the robot's index.
+// Robots which collect no keys are omitted.
+func keyPartition(steps []step) map[int][]byte {
+	partition := make(map[int][]byte)
+	for _, st := range steps {
+		if st.path.dest.cellType == key {
+			partition[st.robot] = append(partition[st.robot], st.path.dest.char)
+		}
+	}
+	return partition
 }
 
-// state represents the current state of a maze traversal, including the list of current positions and the set of collected keys.
-type state struct {
-	cells []*cell
-	keys  keyset
+// printPartition writes a line to w for each robot in steps, giving the keys it collects in the form "robot 1: abc".
+func printPartition(w io.Writer, robots int, steps []step) {
+	partition := keyPartition(steps)
+	for i := 0; i < robots; i++ {
+		fmt.Fprintf(w, "robot %d: %s\n", i+1, partition[i])
+	}
 }
 
-// String returns a unique string representation of s. Used as a map key for memoization.
-func (s state) String() string {
-	cells := make([]byte, len(s.cells))
-	for i := range cells {
-		cells[i] = s.cells[i].char
+// jsonTrace is the JSON representation of the shortest path written by writeTraceJSON.
+type jsonTrace struct {
+	Width  int        `json:"width"`
+	Height int        `json:"height"`
+	Answer int        `json:"answer"`
+	Steps  []jsonStep `json:"steps"`
+}
+
+// jsonStep is the JSON representation of a single move in a jsonTrace.
+type jsonStep struct {
+	Robot  int    `json:"robot"`
+	Key    string `json:"key"`
+	Length int    `json:"length"`
+	Total  int    `json:"total"`
+}
+
+// writeBinaryAnswer writes answer to w as a little-endian int64, followed by the number of keys collected by the moves in steps as another,
+// for a total of 16 bytes.
+func writeBinaryAnswer(w io.Writer, answer int, steps []step) error {
+	var keys int
+	for _, st := range steps {
+		if st.path.dest.cellType == key {
+			keys++
+		}
 	}
-	return fmt.Sprintf("%s%d", cells, s.keys)
+	return binary.Write(w, binary.LittleEndian, [2]int64{int64(answer), int64(keys)})
 }
 
-// copy returns a copy of s.
-func (s state) copy() state {
-	newState := state{cells: make([]*cell, len(s.cells)), keys: s.keys}
-	copy(newState.cells, s.cells)
-	return newState
+// writeTraceJSON writes a JSON object to w describing m, the length of its shortest path, and each of the moves in steps.
+func writeTraceJSON(w io.Writer, m *maze, answer int, steps []step) error {
+	t := jsonTrace{Width: m.w, Height: m.h, Answer: answer, Steps: make([]jsonStep, len(steps))}
+	var total int
+	for i, st := range steps {
+		total += st.path.len
+		t.Steps[i] = jsonStep{Robot: st.robot + 1, Key: string(st.path.dest.char), Length: st.path.len, Total: total}
+	}
+	return json.NewEncoder(w).Encode(t)
 }
@@ -0,0 +1,1209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// examples are the mazes from the puzzle description, with their answers. The first five are from part 1, and the rest from part 2.
+var examples = []struct {
+	name string
+	rows []string
+	want int
+}{
+	{"part 1 example 1", []string{
+		"#########",
+		"#b.A.@.a#",
+		"#########",
+	}, 8},
+	{"part 1 example 2", []string{
+		"########################",
+		"#f.D.E.e.C.b.A.@.a.B.c.#",
+		"######################.#",
+		"#d.....................#",
+		"########################",
+	}, 86},
+	{"part 1 example 3", []string{
+		"########################",
+		"#...............b.C.D.f#",
+		"#.######################",
+		"#.....@.a.B.c.d.A.e.F.g#",
+		"########################",
+	}, 132},
+	{"part 1 example 4", []string{
+		"#################",
+		"#i.G..c...e..H.p#",
+		"########.########",
+		"#j.A..b...f..D.o#",
+		"########@########",
+		"#k.E..a...g..B.n#",
+		"########.########",
+		"#l.F..d...h..C.m#",
+		"#################",
+	}, 136},
+	{"part 1 example 5", []string{
+		"########################",
+		"#@..............ac.GI.b#",
+		"###d#e#f################",
+		"###A#B#C################",
+		"###g#h#i################",
+		"########################",
+	}, 81},
+	{"part 2 example 1", []string{
+		"#######",
+		"#a.#Cd#",
+		"##@#@##",
+		"#######",
+		"##@#@##",
+		"#cB#Ab#",
+		"#######",
+	}, 8},
+	{"part 2 example 2", []string{
+		"###############",
+		"#d.ABC.#.....a#",
+		"######@#@######",
+		"###############",
+		"######@#@######",
+		"#b.....#.....c#",
+		"###############",
+	}, 24},
+	{"part 2 example 3", []string{
+		"#############",
+		"#DcBa.#.GhKl#",
+		"#.###@#@#I###",
+		"#e#d#####j#k#",
+		"###C#@#@###J#",
+		"#fEbA.#.FgHi#",
+		"#############",
+	}, 32},
+	{"part 2 example 4", []string{
+		"#############",
+		"#g#f.D#..h#l#",
+		"#F###e#E###.#",
+		"#dCba@#@BcIJ#",
+		"#############",
+		"#nK.L@#@G...#",
+		"#M###N#H###.#",
+		"#o#m..#i#jk.#",
+		"#############",
+	}, 72},
+}
+
+// mustParse parses rows according to opts, failing the test if they aren't a valid maze.
+func mustParse(t testing.TB, opts parseOptions, rows ...string) *maze {
+	t.Helper()
+	m, err := parseMaze(rows, opts)
+	if err != nil {
+		t.Fatalf("parsing maze: %v", err)
+	}
+	return m
+}
+
+// mustSolve returns the length of the shortest path through m found by sv, failing the test if there is none.
+func mustSolve(t testing.TB, sv *solver, m *maze) int {
+	t.Helper()
+	n, err := sv.solve(context.Background(), state{cells: m.start(), keys: 0})
+	if err != nil {
+		t.Fatalf("solving maze: %v", err)
+	}
+	return n
+}
+
+// keysOf returns the keyset containing each of chars.
+func keysOf(chars string) keyset {
+	var keys keyset
+	for i := 0; i < len(chars); i++ {
+		keys = keys.plus(chars[i])
+	}
+	return keys
+}
+
+func TestExamples(t *testing.T) {
+	for _, ex := range examples {
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := mustSolve(t, newSolver(m, order), m); got != ex.want {
+			t.Errorf("%s: got %d, want %d", ex.name, got, ex.want)
+		}
+	}
+}
+
+func TestKeysNeedDoors(t *testing.T) {
+	labelled := mustParse(t, parseOptions{keysNeedDoors: true},
+		"#############",
+		"#b.A.@.a.B.z#",
+		"#############",
+	)
+	plain := mustParse(t, parseOptions{},
+		"#############",
+		"#b.A.@.a.B..#",
+		"#############",
+	)
+	if labelled.keys != plain.keys {
+		t.Errorf("got keys %s, want %s", labelled.keys, plain.keys)
+	}
+	got, want := mustSolve(t, newSolver(labelled, order), labelled), mustSolve(t, newSolver(plain, order), plain)
+	if got != want {
+		t.Errorf("got %d, want %d as without the label z", got, want)
+	}
+}
+
+func TestOptionalKeys(t *testing.T) {
+	m := mustParse(t, parseOptions{},
+		"#############",
+		"#a.......@.b#",
+		"#############",
+	)
+	if got, want := mustSolve(t, newSolver(m, order), m), 12; got != want {
+		t.Errorf("all keys: got %d, want %d", got, want)
+	}
+	sv := newSolver(m, order)
+	sv.goal = m.keys &^ keyset(0).plus('a')
+	if got, want := mustSolve(t, sv, m), 2; got != want {
+		t.Errorf("a optional: got %d, want %d", got, want)
+	}
+
+	// Optional key x opens door X, a shortcut to b, so it is worth collecting; without the shortcut it is left behind.
+	for _, tc := range []struct {
+		shortcut string
+		want     int
+	}{{"X", 4}, {"#", 10}} {
+		m := mustParse(t, parseOptions{},
+			"#######",
+			"#x@"+tc.shortcut+"b.#",
+			"##.##.#",
+			"##.##.#",
+			"##....#",
+			"#######",
+		)
+		sv := newSolver(m, order)
+		sv.goal = m.keys &^ keysOf("x")
+		if got := mustSolve(t, sv, m); got != tc.want {
+			t.Errorf("x optional with %q between the start and b: got %d, want %d", tc.shortcut, got, tc.want)
+		}
+	}
+}
+
+// setFlag sets the command-line flag with the given name for the rest of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+// runOutput returns everything written to standard output by run(m), failing the test if it returns an error.
+func runOutput(t *testing.T, m *maze) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	err = run(m)
+	os.Stdout = stdout
+	w.Close()
+	out := <-done
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return string(out)
+}
+
+// testAnswerOnly checks that run prints nothing but the answer to the second example under -answer-only, along with the given flag.
+func testAnswerOnly(t *testing.T, name, value string) {
+	t.Helper()
+	setFlag(t, "answer-only", "true")
+	setFlag(t, name, value)
+	m := mustParse(t, parseOptions{}, examples[1].rows...)
+	if got, want := runOutput(t, m), "86\n"; got != want {
+		t.Errorf("-answer-only -%s=%s: got output %q, want %q", name, value, got, want)
+	}
+}
+
+func TestAnswerOnly(t *testing.T) {
+	for _, f := range []struct{ name, value string }{
+		{"trace", "true"}, {"explain", "true"}, {"trace-json", "true"}, {"binary", "true"}, {"hex", "true"}, {"sep", "true"},
+		{"overlay", "true"}, {"partition", "true"}, {"regret", "true"},
+	} {
+		t.Run(f.name, func(t *testing.T) { testAnswerOnly(t, f.name, f.value) })
+	}
+}
+
+func TestBudgetIgnoresRecalls(t *testing.T) {
+	m := mustParse(t, parseOptions{}, examples[0].rows...)
+	sv := newSolver(m, order)
+	sv.recall = 1
+	sv.starts = m.start()
+	walked, steps := sv.collectWithin(state{cells: m.start(), keys: 0}, 10)
+	var keys int
+	for _, st := range steps {
+		if !st.recall {
+			keys++
+		}
+	}
+	if keys != 2 || walked != 7 {
+		t.Errorf("got %d keys in %d steps, want 2 keys in 7 steps", keys, walked)
+	}
+}
+
+func TestDecodeRLELimit(t *testing.T) {
+	if got, err := decodeRLE("3#.@2.a3#", 11); got != "###.@..a###" || err != nil {
+		t.Errorf("within limit: got %q, %v", got, err)
+	}
+	for _, row := range []string{"3#.@2.a3#", "100000000000#", "99999999999999999999999#"} {
+		if _, err := decodeRLE(row, 10); err == nil {
+			t.Errorf("%q: got no error, want one", row)
+		}
+	}
+}
+
+func TestLoadMazeSize(t *testing.T) {
+	for _, size := range [][2]int{{-1, 3}, {3, -1}, {1 << 40, 1 << 40}, {maxSavedCells, 2}} {
+		var b bytes.Buffer
+		if err := gob.NewEncoder(&b).Encode(savedMaze{W: size[0], H: size[1]}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMaze(&b); err == nil {
+			t.Errorf("%dx%d: got no error, want one", size[0], size[1])
+		}
+	}
+}
+
+// endlessRows is a reader which yields its header followed by row, repeated forever.
+type endlessRows struct {
+	header, row string
+	buf         []byte
+}
+
+func (r *endlessRows) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		r.buf = []byte(r.header + r.row + "\n")
+		r.header = ""
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func TestReadMazeLimits(t *testing.T) {
+	// Each input is endless, so readMaze only returns if it checks the size of the maze before reaching the end of it.
+	for _, tc := range []struct{ format, header, row string }{
+		{"text", "", "#.@.#"}, {"rle", "", "#.@.#"}, {"tokens", "", "wall floor start floor wall"}, {"legend", "# wall\n. floor\n@ start\n\n", "#.@.#"},
+	} {
+		r := &endlessRows{header: tc.header, row: tc.row}
+		if _, err := readMaze(r, parseOptions{format: tc.format, maxCells: 100}); !errors.Is(err, errTooLarge) {
+			t.Errorf("-format=%s: got %v, want %v", tc.format, err, errTooLarge)
+		}
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    parseOptions
+		row     string
+		wantErr bool
+	}{
+		{"within limit", parseOptions{maxKeys: 2}, "#a@b#", false},
+		{"over limit", parseOptions{maxKeys: 2}, "#a@bc#", true},
+		// The keys are counted before the hidden passage, which doesn't exist, is added.
+		{"over limit before building", parseOptions{maxKeys: 2, reveals: "z:0,0:0,1"}, "#a@bc#", true},
+		{"keys without doors", parseOptions{maxKeys: 2, keysNeedDoors: true}, "#a@bcA#", false},
+		{"one-way v", parseOptions{maxKeys: 2, oneWay: true}, "#a@bv#", false},
+	} {
+		_, err := parseMaze([]string{tc.row}, tc.opts)
+		if tc.wantErr != (err != nil && strings.Contains(err.Error(), "keys; solver supports at most")) {
+			t.Errorf("%s: got %v, want error %t", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestHiddenPassages(t *testing.T) {
+	for _, tc := range []struct {
+		row, reveals string
+		want         int
+	}{
+		{"#cB@.....b#", "", 14},
+		{"#cB@.....b#", "c:1,4:1,8", 14}, // the passage can't be used before c is collected, which needs b
+		{"#a....@.....b#", "", 16},
+		{"#a....@.....b#", "a:1,7:1,11", 13},
+	} {
+		wall := strings.Repeat("#", len(tc.row))
+		m := mustParse(t, parseOptions{reveals: tc.reveals}, wall, tc.row, wall)
+		if got := mustSolve(t, newSolver(m, order), m); got != tc.want {
+			t.Errorf("%s with -reveal=%q: got %d, want %d", tc.row, tc.reveals, got, tc.want)
+		}
+		if got, err := newSolver(m, order).solveAStar(context.Background(), state{cells: m.start()}); got != tc.want || err != nil {
+			t.Errorf("%s with -reveal=%q: A* got %d, %v, want %d", tc.row, tc.reveals, got, err, tc.want)
+		}
+
+		// The passages must survive saving and loading the maze, and then cropping it, which finds its paths again.
+		var b bytes.Buffer
+		if err := m.Save(&b); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadMaze(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cropped, err := loaded.crop(rect{0, 0, 2, len(tc.row) - 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mustSolve(t, newSolver(cropped, order), cropped); got != tc.want {
+			t.Errorf("%s with -reveal=%q, saved and cropped: got %d, want %d", tc.row, tc.reveals, got, tc.want)
+		}
+	}
+}
+
+func TestNonASCII(t *testing.T) {
+	m := mustParse(t, parseOptions{}, "█████████", "█b.A.@.a█", "█████████")
+	if got := mustSolve(t, newSolver(m, order), m); got != 8 {
+		t.Errorf("walls drawn with █: got %d, want 8", got)
+	}
+	if _, err := parseMaze([]string{"#####", "#@.é#", "#####"}, parseOptions{}); err == nil {
+		t.Error("é: got no error, want one")
+	}
+}
+
+func TestConnectivity(t *testing.T) {
+	rows := []string{
+		"#######",
+		"#@....#",
+		"#.....#",
+		"#.....#",
+		"#....a#",
+		"#######",
+	}
+	for _, tc := range []struct {
+		connectivity string
+		want         int
+	}{
+		{"4", 7}, {"8", 4}, {"hex", 5},
+	} {
+		m := mustParse(t, parseOptions{connectivity: tc.connectivity}, rows...)
+		if got := mustSolve(t, newSolver(m, order), m); got != tc.want {
+			t.Errorf("-connectivity=%s: got %d, want %d", tc.connectivity, got, tc.want)
+		}
+	}
+}
+
+func TestSlashes(t *testing.T) {
+	for _, tc := range []struct {
+		rows    []string
+		slashes bool
+		want    int
+	}{
+		{[]string{"#####", "##.a#", "##/##", "#@.##", "#####"}, true, 2},
+		{[]string{"#####", "##.a#", "##/##", "#@.##", "#####"}, false, 4},
+		{[]string{"#####", "#a.##", `##\##`, "##.@#", "#####"}, true, 2},
+		{[]string{"#####", "#a.##", `##\##`, "##.@#", "#####"}, false, 4},
+	} {
+		m := mustParse(t, parseOptions{slashes: tc.slashes}, tc.rows...)
+		if got := mustSolve(t, newSolver(m, order), m); got != tc.want {
+			t.Errorf("%q with slashes %t: got %d, want %d", tc.rows, tc.slashes, got, tc.want)
+		}
+	}
+
+	// A slash only joins the cells at its ends, so a robot can't get from one side of it to the other.
+	m := mustParse(t, parseOptions{slashes: true}, "#####", "#a.##", "##/##", "##.@#", "#####")
+	if _, err := newSolver(m, order).solve(context.Background(), state{cells: m.start()}); !errors.Is(err, errUnsolvable) {
+		t.Errorf("slash between the robot and the key: got %v, want %v", err, errUnsolvable)
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	for _, ex := range examples {
+		// Write the maze in column-major order, so that -transpose reads it back as the original.
+		columns := make([]string, len(ex.rows[0]))
+		for j := range columns {
+			var b strings.Builder
+			for _, row := range ex.rows {
+				b.WriteByte(row[j])
+			}
+			columns[j] = b.String()
+		}
+		m := mustParse(t, parseOptions{transpose: true}, columns...)
+		if got := mustSolve(t, newSolver(m, order), m); got != ex.want {
+			t.Errorf("%s, transposed: got %d, want %d", ex.name, got, ex.want)
+		}
+		flipped := mustParse(t, parseOptions{}, ex.rows...).transpose()
+		if got := mustSolve(t, newSolver(flipped, order), flipped); got != ex.want {
+			t.Errorf("%s, maze.transpose: got %d, want %d", ex.name, got, ex.want)
+		}
+	}
+}
+
+func TestReadMazesChunked(t *testing.T) {
+	mazes := [][]string{examples[0].rows, examples[1].rows}
+	r, w := io.Pipe()
+	got := make(chan []string)
+	go func() {
+		defer close(got)
+		if err := readMazes(r, "---", func(rows []string) { got <- rows }); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Write one byte at a time, and check that each maze is passed on as soon as its delimiter has been written, before any more input.
+	for i, rows := range mazes {
+		for _, b := range []byte(strings.Join(rows, "\n") + "\n---\n") {
+			if _, err := w.Write([]byte{b}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		select {
+		case rows := <-got:
+			if strings.Join(rows, "\n") != strings.Join(mazes[i], "\n") {
+				t.Errorf("maze %d: got %q, want %q", i+1, rows, mazes[i])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("maze %d wasn't read after its delimiter was written", i+1)
+		}
+	}
+	w.Close()
+	if rows, ok := <-got; ok {
+		t.Errorf("got unexpected maze %q", rows)
+	}
+}
+
+func TestRange(t *testing.T) {
+	m := mustParse(t, parseOptions{},
+		"#########",
+		"#a.....b#",
+		"####.####",
+		"####@####",
+		"#########",
+	)
+	for _, tc := range []struct{ maxRange, want int }{
+		{0, 11}, {6, 11}, {5, 15}, // with a range of 5, the robot has to recharge at its start cell between a and b
+	} {
+		sv := newSolver(m, order)
+		sv.maxRange, sv.starts = tc.maxRange, m.start()
+		if got := mustSolve(t, sv, m); got != tc.want {
+			t.Errorf("-range=%d: got %d, want %d", tc.maxRange, got, tc.want)
+		}
+	}
+	sv := newSolver(m, order)
+	sv.maxRange, sv.starts = 4, m.start()
+	if _, err := sv.solve(context.Background(), state{cells: m.start()}); !errors.Is(err, errUnsolvable) {
+		t.Errorf("-range=4: got %v, want %v", err, errUnsolvable)
+	}
+}
+
+func TestCollectOrder(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		order   string
+		want    int
+	}{
+		{4, "", 81},
+		{4, "d<a", 83}, // the shortest route collects a first, so d has to be fetched before it
+		{4, "a<b", 81}, // the shortest route already collects a before b
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		sv := newSolver(m, order)
+		var err error
+		if sv.before, err = parseCollectOrder(tc.order, m.keys); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustSolve(t, sv, m); got != tc.want {
+			t.Errorf("%s with -collect-order=%q: got %d, want %d", ex.name, tc.order, got, tc.want)
+		}
+	}
+}
+
+func TestCollectOrderUnsolvable(t *testing.T) {
+	// Door A stands between the robot and b, so b can't be collected before a.
+	m := mustParse(t, parseOptions{}, examples[0].rows...)
+	sv := newSolver(m, order)
+	var err error
+	if sv.before, err = parseCollectOrder("b<a", m.keys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.solve(context.Background(), state{cells: m.start()}); !errors.Is(err, errUnsolvable) {
+		t.Errorf("got %v, want %v", err, errUnsolvable)
+	}
+}
+
+func TestConsumeKeys(t *testing.T) {
+	m := mustParse(t, parseOptions{},
+		"#############",
+		"#b.A.@.a.B.c#",
+		"#.#########.#",
+		"#...........#",
+		"#############",
+	)
+	if got, want := mustSolve(t, newSolver(m, order), m), 18; got != want {
+		t.Errorf("without -consume-keys: got %d, want %d", got, want)
+	}
+
+	// Having gone through door A to b, the robot can't come back through it, so it has to go the long way round to c.
+	sv := newSolver(m, order)
+	sv.consume = true
+	if got, want := mustSolve(t, sv, m), 22; got != want {
+		t.Errorf("with -consume-keys: got %d, want %d", got, want)
+	}
+
+	// In the second example, every route has to pass through some door twice.
+	m = mustParse(t, parseOptions{}, examples[1].rows...)
+	sv = newSolver(m, order)
+	sv.consume = true
+	if _, err := sv.solve(context.Background(), state{cells: m.start()}); !errors.Is(err, errUnsolvable) {
+		t.Errorf("%s with -consume-keys: got %v, want %v", examples[1].name, err, errUnsolvable)
+	}
+}
+
+func TestDoorCost(t *testing.T) {
+	m := mustParse(t, parseOptions{},
+		"#######",
+		"#a@A.b#",
+		"#.###.#",
+		"#.....#",
+		"#######",
+	)
+	for _, tc := range []struct{ doorCost, want int }{
+		{0, 5}, {3, 8}, // the shortest route goes back through the start and door A to b
+		{4, 9}, {5, 9}, {100, 9}, // once the door costs more than 4, the detour round the bottom corridor is cheaper
+	} {
+		sv := newSolver(m, order)
+		sv.doorCost = tc.doorCost
+		if got := mustSolve(t, sv, m); got != tc.want {
+			t.Errorf("-door-cost=%d: got %d, want %d", tc.doorCost, got, tc.want)
+		}
+	}
+}
+
+func TestFuel(t *testing.T) {
+	m := mustParse(t, parseOptions{},
+		"#############",
+		"#...###.a...#",
+		"#...........#",
+		"#c#.@.b...#.#",
+		"#############",
+	)
+	for _, tc := range []struct{ fuel, want int }{
+		{0, 15}, {9, 15}, {7, 16}, // the shortest route ends with a move of 9 steps from a to c, so on 7 units c has to be fetched first
+	} {
+		sv := newSolver(m, order)
+		sv.fuel = tc.fuel
+		if got := mustSolve(t, sv, m); got != tc.want {
+			t.Errorf("-fuel=%d: got %d, want %d", tc.fuel, got, tc.want)
+		}
+	}
+	sv := newSolver(m, order)
+	sv.fuel = 6
+	if _, err := sv.solve(context.Background(), state{cells: m.start()}); !errors.Is(err, errUnsolvable) {
+		t.Errorf("-fuel=6: got %v, want %v", err, errUnsolvable)
+	}
+}
+
+func TestManhattanBound(t *testing.T) {
+	for _, ex := range examples {
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		for i := range m.rows {
+			for _, c := range m.rows[i] {
+				if c == nil || c.cellType != key {
+					continue
+				}
+				for _, p := range c.paths {
+					if p.dest.cellType != key {
+						continue
+					}
+					if bound := m.manhattanBound(c.char, p.dest.char); bound > p.len {
+						t.Errorf("%s: bound %d from %c to %c is longer than the path of length %d", ex.name, bound, c.char, p.dest.char, p.len)
+					}
+				}
+			}
+		}
+		if got := m.manhattanBound('a', '?'); got != -1 {
+			t.Errorf("%s: got bound %d to a missing key, want -1", ex.name, got)
+		}
+	}
+
+	// The bound is exact along a straight corridor.
+	m := mustParse(t, parseOptions{}, examples[0].rows...)
+	if got, want := m.manhattanBound('a', 'b'), 6; got != want {
+		t.Errorf("a to b in %s: got %d, want %d", examples[0].name, got, want)
+	}
+}
+
+// BenchmarkSolve measures the solver on the fourth example, the largest from the puzzle, with each of the options which affect its speed.
+func BenchmarkSolve(b *testing.B) {
+	m := mustParse(b, parseOptions{}, examples[3].rows...)
+	for _, bc := range []struct {
+		name      string
+		configure func(sv *solver)
+	}{
+		{"default", func(*solver) {}},
+		{"compress-state", func(sv *solver) { sv.compactTable = make(map[compactState]int) }},
+		{"cache-segments", func(sv *solver) { sv.segments = make(map[segment][]path) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sv := newSolver(m, order)
+				bc.configure(sv)
+				mustSolve(b, sv, m)
+			}
+		})
+	}
+}
+
+func TestSearchOrders(t *testing.T) {
+	for _, o := range []searchOrder{indexOrder, nearestFirst, farthestFirst} {
+		for _, ex := range examples {
+			m := mustParse(t, parseOptions{}, ex.rows...)
+			if got := mustSolve(t, newSolver(m, o), m); got != ex.want {
+				t.Errorf("%s with -order=%s: got %d, want %d", ex.name, o, got, ex.want)
+			}
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		order   string
+		want    int
+		wantErr bool
+	}{
+		{0, "ab", 8, false},
+		{1, "abcdef", 86, false},
+		{2, "bacdfeg", 132, false},
+		{4, "acfidgbeh", 81, false},
+		{5, "abcd", 8, false},
+		{0, "ba", 0, true}, // b is behind door A
+		{0, "az", 0, true}, // z isn't in the maze
+		{0, "aa", 0, true}, // a can only be collected once
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		got, err := m.walk([]byte(tc.order))
+		switch {
+		case tc.wantErr && err == nil:
+			t.Errorf("%s, walking %s: got %d, want an error", ex.name, tc.order, got)
+		case !tc.wantErr && (err != nil || got != tc.want):
+			t.Errorf("%s, walking %s: got %d, %v, want %d", ex.name, tc.order, got, err, tc.want)
+		}
+	}
+}
+
+func TestConcatHorizontally(t *testing.T) {
+	first := mustParse(t, parseOptions{}, examples[0].rows...)
+	for _, tc := range []struct {
+		name    string
+		b       *maze
+		w, want int
+		wantErr bool
+	}{
+		{"relabelled copy", mustParse(t, parseOptions{}, "#########", "#d.C.@.c#", "#########"), 19, 16, false},
+		{"corridor", mustParse(t, parseOptions{}, "#####", "#@.e#", "#####"), 15, 10, false},
+		{"different height", mustParse(t, parseOptions{}, examples[1].rows...), 0, 0, true},
+		{"shared keys", mustParse(t, parseOptions{}, examples[0].rows...), 0, 0, true},
+		{"hidden passage", mustParse(t, parseOptions{reveals: "x:1,3:1,11"}, "##############", "#x@........yc#", "##############"), 24, 13, false},
+		{"slashes", mustParse(t, parseOptions{slashes: true}, examples[0].rows...), 0, 0, true},
+	} {
+		m, err := concatHorizontally(first, tc.b)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: got no error, want one", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if m.w != tc.w || m.h != first.h {
+			t.Errorf("%s: got a %dx%d maze, want %dx%d", tc.name, m.w, m.h, tc.w, first.h)
+		}
+		if got := mustSolve(t, newSolver(m, order), m); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConcatHorizontallySlashes(t *testing.T) {
+	// The robot on the right can only reach e along the slash, which must still be joined only to its ends.
+	opts := parseOptions{slashes: true}
+	a := mustParse(t, opts, "#####", "#@.a#", "#...#", "#...#", "#####")
+	b := mustParse(t, opts, "#####", "#@###", "##\\##", "###e#", "#####")
+	m, err := concatHorizontally(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustSolve(t, newSolver(m, order), m), 4; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestFrontier(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    map[byte]int
+	}{
+		{0, map[byte]int{'a': 2}},
+		{1, map[byte]int{'a': 2}},
+		{2, map[byte]int{'a': 2, 'b': 22}},
+		{3, map[byte]int{'a': 3, 'b': 3, 'c': 5, 'e': 5, 'f': 3, 'g': 3, 'h': 5, 'd': 5}},
+		{5, map[byte]int{'a': 2}},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := m.frontier(state{cells: m.start()}); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", ex.name, got, tc.want)
+		}
+	}
+}
+
+func TestFreeKeys(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    string
+	}{
+		{0, "a"},
+		{1, "a"},
+		{2, "ab"},
+		{3, "abcdefgh"},
+		{4, "acdef"},
+		{5, "a"},
+		{7, "a"},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := m.freeKeys().String(); got != tc.want {
+			t.Errorf("%s: got %s, want %s", ex.name, got, tc.want)
+		}
+	}
+}
+
+func TestNearestKeys(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		keys    string
+		want    []byte
+	}{
+		{0, "", []byte{'a'}},
+		{0, "a", []byte{'b'}},
+		{1, "ab", []byte{'c'}},
+		{3, "", []byte{'a'}}, // a, b, f and g are all 3 steps away
+		{5, "", []byte{'a', 0, 0, 0}},
+		{5, "a", []byte{0, 0, 0, 'b'}},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := m.nearestKeys(state{cells: m.start(), keys: keysOf(tc.keys)}); !bytes.Equal(got, tc.want) {
+			t.Errorf("%s holding %q: got %q, want %q", ex.name, tc.keys, got, tc.want)
+		}
+	}
+}
+
+func TestJunctions(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    [][2]int
+	}{
+		{0, nil},
+		{1, nil},
+		{3, [][2]int{{1, 8}, {3, 8}, {5, 8}, {7, 8}}},
+		{4, [][2]int{{1, 3}, {1, 5}, {1, 7}}},
+		{6, nil},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		var got [][2]int
+		for _, c := range m.junctions() {
+			got = append(got, [2]int{c.row, c.col})
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", ex.name, got, tc.want)
+		}
+	}
+}
+
+func TestEquivalentKeyGroups(t *testing.T) {
+	for _, ex := range examples {
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := m.equivalentKeyGroups(); len(got) != 0 {
+			t.Errorf("%s: got %q, want no groups", ex.name, got)
+		}
+	}
+	for _, tc := range []struct {
+		rows []string
+		want [][]byte
+	}{
+		{[]string{"#########", "#a..@..b#", "#########"}, [][]byte{[]byte("ab")}},
+		{[]string{"#########", "#a.B@.Ab#", "#########"}, [][]byte{[]byte("ab")}}, // swapping the keys swaps their doors too
+		{[]string{"###########", "#a..@..b.c#", "###########"}, nil},              // a and b are as far from the start, but not from c
+	} {
+		m := mustParse(t, parseOptions{}, tc.rows...)
+		if got := m.equivalentKeyGroups(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.rows, got, tc.want)
+		}
+	}
+}
+
+func TestReachableCellCount(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		char    byte // a key in the maze, or '@' for each start cell
+		want    int
+	}{
+		{0, '@', 6},
+		{0, 'b', 6}, // doors don't block the count
+		{1, '@', 44},
+		{3, 'p', 62},
+		{5, '@', 2}, // each robot is walled into its own quadrant
+		{6, '@', 6},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		cells := m.start()
+		if tc.char != '@' {
+			cells = []*cell{keyCell(t, m, tc.char)}
+		}
+		for _, c := range cells {
+			if got := m.reachableCellCount(c); got != tc.want {
+				t.Errorf("%s from %c at %d,%d: got %d, want %d", ex.name, c.char, c.row, c.col, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestDoorUnlockOrder(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    string
+	}{
+		{0, "A"},
+		{1, "ABCDE"},
+		{2, "ABCDF"},
+		{3, "ABCDEFGH"},
+		{4, "ACGIB"}, // a and c are free, then g and i, behind A and C, open G and I in front of b
+		{5, "ABC"},
+		{8, "BECHDIFGJKLNM"},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := string(m.doorUnlockOrder()); got != tc.want {
+			t.Errorf("%s: got %s, want %s", ex.name, got, tc.want)
+		}
+	}
+
+	// There is no key a, so b, behind door A, can't be reached, and door B never becomes openable.
+	m := mustParse(t, parseOptions{}, "#########", "#b.A.@.B#", "#########")
+	if got, want := string(m.doorUnlockOrder()), ""; got != want {
+		t.Errorf("b behind a door with no key: got %q, want %q", got, want)
+	}
+}
+
+func TestUnlockStepsAntiKeys(t *testing.T) {
+	// Collecting d removes a, which robot 1 has to collect again, but door A was already opened when a was first collected.
+	m := mustParse(t, parseOptions{}, examples[5].rows...)
+	sv := newSolver(m, order)
+	var err error
+	if sv.removes, err = parseAntiKeys("d:a", m.keys); err != nil {
+		t.Fatal(err)
+	}
+	initial := state{cells: m.start()}
+	mustSolve(t, sv, m)
+	if got, want := unlockSteps(sv.trace(initial))['a'], 2; got != want {
+		t.Errorf("got door A opened at step %d, want %d", got, want)
+	}
+}
+
+func TestAntiKeysStandingOnKey(t *testing.T) {
+	// Robot 1 is still standing on a when d removes it, so it picks a up again without moving.
+	m := mustParse(t, parseOptions{}, examples[5].rows...)
+	sv := newSolver(m, order)
+	var err error
+	if sv.removes, err = parseAntiKeys("d:a", m.keys); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustSolve(t, sv, m), 8; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	var b strings.Builder
+	printTrace(&b, state{cells: m.start()}, sv.trace(state{cells: m.start()}), false)
+	if want := "robot 1: picked up key a again\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("got trace\n%s\nwant it to contain %q", b.String(), want)
+	}
+}
+
+func TestExplanation(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    *maze
+		want string
+	}{
+		// Door A was opened on the way to b, so it isn't opened again on the way back through it to c.
+		{examples[1].name, mustParse(t, parseOptions{}, examples[1].rows...), "Robot 1 walked 10 to key c, opening door B using key b (collected by robot 1).\n"},
+		// The hidden passage revealed by x is not a door.
+		{"hidden passage", mustParse(t, parseOptions{reveals: "x:1,3:1,11"}, "##############", "#x@........yc#", "##############"), "Robot 1 walked 3 to key y (no doors).\n"},
+	} {
+		initial := state{cells: tc.m.start()}
+		sv := newSolver(tc.m, order)
+		mustSolve(t, sv, tc.m)
+		var b strings.Builder
+		printExplanation(&b, initial, sv.trace(initial))
+		if !strings.Contains(b.String(), tc.want) {
+			t.Errorf("%s: got\n%s\nwant it to contain %q", tc.name, b.String(), tc.want)
+		}
+	}
+}
+
+func TestInvalidGoals(t *testing.T) {
+	// These are rejected before any goal is solved, so nothing is printed.
+	m := mustParse(t, parseOptions{}, examples[1].rows...)
+	for _, goals := range []string{"ab;d;", ";ab", "", "az"} {
+		if err := newSolver(m, order).solveGoals(context.Background(), state{cells: m.start()}, goals); err == nil {
+			t.Errorf("-goals=%q: got no error, want one", goals)
+		}
+	}
+}
+
+func TestDependencyDepth(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    int
+	}{
+		{0, 1},
+		{1, 4}, // f is behind E, e behind C, c behind B and b behind A
+		{2, 3},
+		{3, 1},
+		{5, 3},
+		{6, 1},
+		{7, 11},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := m.dependencyDepth(); got != tc.want {
+			t.Errorf("%s: got %d, want %d", ex.name, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		rows []string
+		want int
+	}{
+		{"no doors", []string{"#######", "#a.@.b#", "#######"}, 0},
+		{"cycle", []string{"###########", "#b.A.@.B.a#", "###########"}, -1},
+	} {
+		m := mustParse(t, parseOptions{}, tc.rows...)
+		if got := m.dependencyDepth(); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestKeyBounds(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    [4]int
+	}{
+		{0, [4]int{1, 1, 1, 7}},
+		{1, [4]int{1, 1, 3, 21}},
+		{2, [4]int{1, 8, 3, 22}},
+		{3, [4]int{1, 1, 7, 15}},
+		{4, [4]int{1, 3, 4, 22}},
+		{5, [4]int{1, 1, 5, 5}},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if r1, c1, r2, c2 := m.keyBounds(); [4]int{r1, c1, r2, c2} != tc.want {
+			t.Errorf("%s: got %d,%d,%d,%d, want %d,%d,%d,%d", ex.name, r1, c1, r2, c2, tc.want[0], tc.want[1], tc.want[2], tc.want[3])
+		}
+	}
+	m := mustParse(t, parseOptions{}, "#####", "#@..#", "#####")
+	if r1, c1, r2, c2 := m.keyBounds(); [4]int{r1, c1, r2, c2} != [4]int{-1, -1, -1, -1} {
+		t.Errorf("no keys: got %d,%d,%d,%d, want -1,-1,-1,-1", r1, c1, r2, c2)
+	}
+}
+
+func TestUnlockThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 1},
+		{4, 1},
+		{6, 3}, // d is behind A, B and C, and no other key is behind a door
+		{7, 1},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		if got := m.unlockThreshold(); got != tc.want {
+			t.Errorf("%s: got %d, want %d", ex.name, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		rows []string
+		want int
+	}{
+		{"no doors", []string{"#######", "#a.@.b#", "#######"}, 0},
+		{"cycle", []string{"###########", "#b.A.@.B.a#", "###########"}, -1},
+	} {
+		m := mustParse(t, parseOptions{}, tc.rows...)
+		if got := m.unlockThreshold(); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestComponents(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		sizes   []int // the number of cells in each component
+	}{
+		{0, []int{7}},
+		{1, []int{45}},
+		{3, []int{63}},
+		{5, []int{3, 3, 3, 3}},
+		{6, []int{7, 7, 7, 7}},
+		{8, []int{11, 11, 11, 11}},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		var sizes []int
+		starts := 0
+		for _, component := range m.components() {
+			sizes = append(sizes, len(component))
+			for _, c := range component {
+				if c.cellType == start {
+					starts++
+				}
+			}
+		}
+		if !reflect.DeepEqual(sizes, tc.sizes) {
+			t.Errorf("%s: got components of sizes %v, want %v", ex.name, sizes, tc.sizes)
+		}
+		if starts != len(m.start()) {
+			t.Errorf("%s: got %d start cells in the components, want %d", ex.name, starts, len(m.start()))
+		}
+	}
+
+	// Components are ordered by their first cell, in row-major order.
+	m := mustParse(t, parseOptions{}, "#######", "#@.#.a#", "#######", "#b.#..#", "#######")
+	var first [][2]int
+	for _, component := range m.components() {
+		first = append(first, [2]int{component[0].row, component[0].col})
+	}
+	if want := [][2]int{{1, 1}, {1, 4}, {3, 1}, {3, 4}}; !reflect.DeepEqual(first, want) {
+		t.Errorf("got components starting at %v, want %v", first, want)
+	}
+}
+
+func TestTypeGrid(t *testing.T) {
+	// Each expected row gives the type of each cell as a digit: 0 empty, 1 start, 2 key, 3 door and 4 wall.
+	for _, tc := range []struct {
+		name string
+		m    *maze
+		want []string
+	}{
+		{examples[0].name, mustParse(t, parseOptions{}, examples[0].rows...), []string{
+			"444444444",
+			"420301024",
+			"444444444",
+		}},
+		{examples[5].name, mustParse(t, parseOptions{}, examples[5].rows...), []string{
+			"4444444",
+			"4204324",
+			"4414144",
+			"4444444",
+			"4414144",
+			"4234324",
+			"4444444",
+		}},
+		// With -keys-need-doors, z has no door, so it is an empty cell rather than a key.
+		{"key without a door", mustParse(t, parseOptions{keysNeedDoors: true}, "#############", "#b.A.@.a.B.z#", "#############"), []string{
+			"4444444444444",
+			"4203010203004",
+			"4444444444444",
+		}},
+	} {
+		grid := tc.m.TypeGrid()
+		got := make([]string, len(grid))
+		for i, row := range grid {
+			b := make([]byte, len(row))
+			for j, typ := range row {
+				b[j] = '0' + byte(typ)
+			}
+			got[i] = string(b)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSolveBothChecks(t *testing.T) {
+	// Each of these is rejected before anything is solved, with the same exit code as run would give.
+	m := mustParse(t, parseOptions{}, "#######", "#a...b#", "#.....#", "#..@..#", "#.....#", "#c...d#", "#######")
+	if err := solveBoth(m, parseOptions{format: "gob"}); exitCode(err) != exitParseError {
+		t.Errorf("-format=gob: got %v, want a parse error", err)
+	}
+	setFlag(t, "robots", "4")
+	if err := solveBoth(m, parseOptions{}); exitCode(err) != exitParseError {
+		t.Errorf("-robots=4: got %v, want a parse error", err)
+	}
+}
+
+func TestShortestPathOrdered(t *testing.T) {
+	for _, tc := range []struct {
+		example int
+		want    int // or -1 if the keys can't be collected in alphabetical order
+	}{
+		{0, 8},
+		{1, 86},  // the shortest route already collects the keys in order
+		{2, 144}, // rather than 132, since the shortest route collects b before a
+		{3, 178},
+		{4, -1}, // b is behind doors G and I
+		{5, 8},
+		{6, 24},
+		{7, 32},
+		{8, -1},
+	} {
+		ex := examples[tc.example]
+		m := mustParse(t, parseOptions{}, ex.rows...)
+		got, err := shortestPathOrdered(m)
+		switch {
+		case tc.want < 0 && !errors.Is(err, errUnsolvable):
+			t.Errorf("%s: got %d, %v, want %v", ex.name, got, err, errUnsolvable)
+		case tc.want >= 0 && (err != nil || got != tc.want):
+			t.Errorf("%s: got %d, %v, want %d", ex.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestReturnHomeHiddenPassages(t *testing.T) {
+	// With both passages revealed there are two paths from b back to the start, and the robot must take the shorter.
+	for _, reveals := range []string{"x:1,3:1,11", "x:1,3:1,11;y:1,3:1,9"} {
+		m := mustParse(t, parseOptions{reveals: reveals}, "##############", "#x@........yb#", "##############")
+		sv := newSolver(m, order)
+		sv.returnHome, sv.starts = true, m.start()
+		if got, want := mustSolve(t, sv, m), 8; got != want {
+			t.Errorf("-reveal=%q -return: got %d, want %d", reveals, got, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// parsePortalMaze builds a maze from rows in the style of the day 20 puzzle, in which '.' is an open cell, every other character is a wall,
+// and pairs of upper-case letters next to an open cell label a portal. The two open cells with the same label are joined to each other,
+// so that a robot can step between them. The cell labelled AA is the start cell and the cell labelled ZZ is the only key, z,
+// so the answer is the length of the shortest path from AA to ZZ.
+func parsePortalMaze(rows []string) (*maze, error) {
+	var w int
+	for _, row := range rows {
+		if len(row) > w {
+			w = len(row)
+		}
+	}
+	at := func(i, j int) byte {
+		if i < 0 || i >= len(rows) || j < 0 || j >= len(rows[i]) {
+			return ' '
+		}
+		return rows[i][j]
+	}
+	isLetter := func(char byte) bool {
+		return 'A' <= char && char <= 'Z'
+	}
+
+	// Find the open cell next to each label. A label reads left to right or top to bottom, and its open cell is at one end or the other.
+	labels := make(map[[2]int]string)
+	ends := make(map[string][][2]int)
+	addLabel := func(label string, cells ...[2]int) {
+		for _, pos := range cells {
+			if at(pos[0], pos[1]) == '.' {
+				labels[pos] = label
+				ends[label] = append(ends[label], pos)
+			}
+		}
+	}
+	for i := range rows {
+		for j := 0; j < len(rows[i]); j++ {
+			if !isLetter(at(i, j)) {
+				continue
+			}
+			if isLetter(at(i, j+1)) {
+				addLabel(string([]byte{at(i, j), at(i, j+1)}), [2]int{i, j - 1}, [2]int{i, j + 2})
+			}
+			if isLetter(at(i+1, j)) {
+				addLabel(string([]byte{at(i, j), at(i+1, j)}), [2]int{i - 1, j}, [2]int{i + 2, j})
+			}
+		}
+	}
+
+	m := newMaze(w, len(rows))
+	for i := range rows {
+		for j := 0; j < len(rows[i]); j++ {
+			if rows[i][j] != '.' {
+				continue
+			}
+			char := byte('.')
+			switch labels[[2]int{i, j}] {
+			case "AA":
+				char = '@'
+			case "ZZ":
+				char = 'z'
+			}
+			m.addCell(i, j, newCell(char))
+		}
+	}
+	if len(ends["AA"]) != 1 || len(ends["ZZ"]) != 1 {
+		return nil, errors.New("maze must contain exactly one AA and one ZZ label")
+	}
+
+	// Join the portals in order of their labels, since the order of each cell's adjacency list determines which path is found
+	// when there are several of the same length.
+	var names []string
+	for label := range ends {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	for _, label := range names {
+		if label == "AA" || label == "ZZ" {
+			continue
+		}
+		cells := ends[label]
+		if len(cells) != 2 {
+			return nil, fmt.Errorf("portal %s has %d ends, expected 2", label, len(cells))
+		}
+		m.rows[cells[0][0]][cells[0][1]].join(m.rows[cells[1][0]][cells[1][1]])
+	}
+	m.buildPaths()
+	return m, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// render returns the rows of m as text, with a robot ('@') in each of the given cells, and with collected keys and the doors they open shown as empty cells.
+func (m *maze) render(robots []*cell, keys keyset) [][]byte {
+	grid := make([][]byte, m.h)
+	for i := range m.rows {
+		grid[i] = make([]byte, m.w)
+		for j, c := range m.rows[i] {
+			switch {
+			case c == nil:
+				grid[i][j] = '#'
+			case c.cellType == start:
+				grid[i][j] = '.'
+			case c.cellType == key && keys.contains(c.char), c.cellType == door && keys.contains(c.char|32):
+				grid[i][j] = '.'
+			default:
+				grid[i][j] = c.char
+			}
+		}
+	}
+	for _, c := range robots {
+		grid[c.row][c.col] = '@'
+	}
+	return grid
+}
+
+// overlay returns the rows of m as text with the route of the shortest path from s, as given by steps, drawn over it: each empty cell which
+// the route passes through once is shown as '+', and each which it passes through more than once, where the route doubles back on itself or
+// crosses another robot's route, is shown as '*'. Keys, doors and start cells are shown as they are in the maze.
+func (m *maze) overlay(s state, steps []step) [][]byte {
+	grid := m.render(nil, 0)
+	for _, c := range s.cells {
+		grid[c.row][c.col] = c.char
+	}
+	visits := make(map[*cell]int)
+	for _, st := range steps {
+		for _, c := range st.cells(s)[1:] {
+			visits[c]++
+		}
+		s = s.next(st)
+	}
+	for c, n := range visits {
+		switch {
+		case c.cellType != empty:
+		case n == 1:
+			grid[c.row][c.col] = '+'
+		default:
+			grid[c.row][c.col] = '*'
+		}
+	}
+	return grid
+}
+
+// writeFrames writes a frame to dir for each position along the shortest path from s, as given by steps, so that the frames can be assembled into
+// an animation. Each robot moves one cell per frame. The frames are named frame00000.txt, frame00001.txt and so on, or have the extension .ppm
+// if ppm is true, in which case each frame is a PPM image in which each cell is drawn as a block of colour.
+func writeFrames(dir string, m *maze, s state, steps []step, ppm bool) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	ext := ".txt"
+	if ppm {
+		ext = ".ppm"
+	}
+	var n int
+	write := func() error {
+		name := filepath.Join(dir, fmt.Sprintf("frame%05d%s", n, ext))
+		n++
+		grid := m.render(s.cells, s.keys)
+		if ppm {
+			return writePPM(name, grid)
+		}
+		return writeText(name, grid)
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	for _, st := range steps {
+		// Keys walked over on the way aren't collected: only the key at the end of the step is, once the robot reaches it.
+		next := s.next(st)
+		cells := st.cells(s)[1:]
+		for i, c := range cells {
+			s = s.copy()
+			s.cells[st.robot] = c
+			if i == len(cells)-1 {
+				s = next
+			}
+			if err := write(); err != nil {
+				return err
+			}
+		}
+		s = next
+	}
+	return nil
+}
+
+// writeText writes grid to the file with the given name, one row per line.
+func writeText(name string, grid [][]byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, row := range grid {
+		w.Write(row)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ppmBlock is the width and height in pixels of each cell in a PPM frame.
+const ppmBlock = 8
+
+// ppmColour returns the colour in which a cell containing char is drawn in a PPM frame.
+func ppmColour(char byte) [3]byte {
+	switch {
+	case char == '#':
+		return [3]byte{0, 0, 0}
+	case char == '@':
+		return [3]byte{220, 30, 30}
+	case 'a' <= char && char <= 'z':
+		return [3]byte{240, 200, 0}
+	case 'A' <= char && char <= 'Z':
+		return [3]byte{120, 70, 20}
+	}
+	return [3]byte{255, 255, 255}
+}
+
+// writePPM writes grid to the file with the given name as a binary PPM image, in which each cell is a block of ppmBlock by ppmBlock pixels.
+func writePPM(name string, grid [][]byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var width int
+	if len(grid) > 0 {
+		width = len(grid[0])
+	}
+	fmt.Fprintf(w, "P6\n%d %d\n255\n", width*ppmBlock, len(grid)*ppmBlock)
+	for _, row := range grid {
+		for y := 0; y < ppmBlock; y++ {
+			for _, char := range row {
+				colour := ppmColour(char)
+				for x := 0; x < ppmBlock; x++ {
+					w.Write(colour[:])
+				}
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
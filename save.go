@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// maxSavedCells is the largest area, in cells, of a maze which LoadMaze will load, so that a corrupt file can't exhaust memory.
+const maxSavedCells = 1 << 26
+
+// savedMaze is the gob-encoded form of a maze. Cells refer to each other by their index in Cells, rather than by pointer.
+type savedMaze struct {
+	W, H  int
+	Keys  keyset
+	Cells []savedCell
+}
+
+// savedCell is the gob-encoded form of a cell.
+type savedCell struct {
+	Char     byte
+	Row, Col int
+	Type     cellType
+	Exit     direction
+	Adj      []int
+	Hidden   map[int]byte // the key which reveals each hidden passage, by the index of the cell it leads to
+	Paths    []savedPath
+}
+
+// savedPath is the gob-encoded form of a path.
+type savedPath struct {
+	Len     int
+	Dest    int
+	ReqKeys keyset
+}
+
+// Save writes a gob encoding of m to w, including the paths between its cells, so that it can be read by LoadMaze without being parsed again.
+func (m *maze) Save(w io.Writer) error {
+	ids := make(map[*cell]int)
+	var cells []*cell
+	for i := range m.rows {
+		for _, c := range m.rows[i] {
+			if c != nil {
+				ids[c] = len(cells)
+				cells = append(cells, c)
+			}
+		}
+	}
+	saved := savedMaze{W: m.w, H: m.h, Keys: m.keys, Cells: make([]savedCell, len(cells))}
+	for i, c := range cells {
+		sc := savedCell{Char: c.char, Row: c.row, Col: c.col, Type: c.cellType, Exit: c.exit}
+		for _, adj := range c.adj {
+			sc.Adj = append(sc.Adj, ids[adj])
+		}
+		for adj, char := range c.hidden {
+			if sc.Hidden == nil {
+				sc.Hidden = make(map[int]byte)
+			}
+			sc.Hidden[ids[adj]] = char
+		}
+		for _, p := range c.paths {
+			sc.Paths = append(sc.Paths, savedPath{Len: p.len, Dest: ids[p.dest], ReqKeys: p.reqKeys})
+		}
+		saved.Cells[i] = sc
+	}
+	return gob.NewEncoder(w).Encode(saved)
+}
+
+// LoadMaze reads a maze written by Save from r. It returns an error if the maze is more than maxSavedCells cells in area.
+func LoadMaze(r io.Reader) (*maze, error) {
+	var saved savedMaze
+	if err := gob.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("loading maze: %w", err)
+	}
+	if saved.W < 0 || saved.H < 0 || saved.H > 0 && saved.W > maxSavedCells/saved.H {
+		return nil, fmt.Errorf("loading maze: invalid size %dx%d", saved.W, saved.H)
+	}
+	m := newMaze(saved.W, saved.H)
+	m.keys = saved.Keys
+	cells := make([]*cell, len(saved.Cells))
+	for i, sc := range saved.Cells {
+		if sc.Row < 0 || sc.Row >= m.h || sc.Col < 0 || sc.Col >= m.w {
+			return nil, fmt.Errorf("loading maze: cell %d is outside the maze", i)
+		}
+		cells[i] = &cell{char: sc.Char, row: sc.Row, col: sc.Col, cellType: sc.Type, exit: sc.Exit}
+		m.rows[sc.Row][sc.Col] = cells[i]
+	}
+	lookup := func(id int) (*cell, error) {
+		if id < 0 || id >= len(cells) {
+			return nil, fmt.Errorf("loading maze: no cell with id %d", id)
+		}
+		return cells[id], nil
+	}
+	for i, sc := range saved.Cells {
+		for _, id := range sc.Adj {
+			adj, err := lookup(id)
+			if err != nil {
+				return nil, err
+			}
+			cells[i].link(adj)
+		}
+		for id, char := range sc.Hidden {
+			adj, err := lookup(id)
+			if err != nil {
+				return nil, err
+			}
+			cells[i].hide(adj, char)
+		}
+		for _, sp := range sc.Paths {
+			dest, err := lookup(sp.Dest)
+			if err != nil {
+				return nil, err
+			}
+			cells[i].paths = append(cells[i].paths, path{len: sp.Len, dest: dest, reqKeys: sp.ReqKeys})
+		}
+	}
+	return m, nil
+}
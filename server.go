@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultRequestTimeout is the time allowed to solve each maze sent to the server if the -timeout flag is not set.
+const defaultRequestTimeout = 10 * time.Second
+
+// maxRequestBytes is the largest request body the server will read, so that a maze too large to solve can't exhaust its memory
+// even if no size limits are set, or the format is one whose size can't be checked until the whole maze has been read.
+const maxRequestBytes = 16 << 20
+
+// solution is the JSON representation of a solved maze returned by the server.
+type solution struct {
+	Steps int    `json:"steps"`
+	Keys  string `json:"keys"`
+}
+
+// listenAndServe starts an HTTP server on addr which solves mazes sent to the /solve endpoint, parsing them according to opts.
+func listenAndServe(addr string, opts parseOptions) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/solve", func(w http.ResponseWriter, r *http.Request) {
+		handleSolve(w, r, opts)
+	})
+	return http.ListenAndServe(addr, mux)
+}
+
+// handleSolve reads a maze from the body of r, solves it and writes the solution to w as JSON.
+func handleSolve(w http.ResponseWriter, r *http.Request, opts parseOptions) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d := *timeout
+	if d == 0 {
+		d = defaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	defer cancel()
+
+	m, err := readMaze(http.MaxBytesReader(w, r.Body, maxRequestBytes), opts)
+	if errors.As(err, new(*http.MaxBytesError)) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	initial := state{cells: m.start(), keys: 0}
+	sv, err := configureSolver(m, initial)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	steps, err := sv.solve(ctx, initial)
+	if errors.Is(err, errUnsolvable) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, "no solution found: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	var keys []byte
+	for _, move := range sv.trace(initial) {
+		keys = append(keys, move.path.dest.char)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(solution{Steps: steps, Keys: string(keys)})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// treeEdge is a move explored by shortestPath, recorded for -tree.
+type treeEdge struct {
+	from, to string // the string representations of the states before and after the move
+	move     step
+	memoized bool // whether the distance from the next state had already been memoized, so that it wasn't explored again
+}
+
+// recordEdge records the move from s to next in sv.tree, if the tree is being recorded and s is shallower than sv.treeDepth.
+// The depth of a state is the number of keys collected.
+func (sv *solver) recordEdge(s, next state, move step) {
+	if sv.treeDepth == 0 || s.keys.len() >= sv.treeDepth {
+		return
+	}
+	_, memoized := sv.lookup(next)
+	sv.tree = append(sv.tree, treeEdge{from: s.String(), to: next.String(), move: move, memoized: memoized})
+}
+
+// writeTree writes the edges recorded in sv.tree to the file with the given name as a DOT graph, in which each edge is labelled with the key
+// collected and the length of the move, and edges to states whose distance had already been memoized are dashed.
+func (sv *solver) writeTree(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "digraph search {")
+	for _, e := range sv.tree {
+		style := ""
+		if e.memoized {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(w, "\t%q -> %q [label=\"%c %d\"%s];\n", e.from, e.to, e.move.path.dest.char, e.move.path.len, style)
+	}
+	fmt.Fprintln(w, "}")
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runTUI steps through the shortest path from s, as given by steps, redrawing m on the terminal after each move. The user presses enter to
+// advance one move, or types q and presses enter to quit. Input is read from the terminal rather than standard input, since the maze may
+// have been read from standard input.
+func runTUI(m *maze, s state, steps []step) error {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return fmt.Errorf("-tui needs a terminal: %w", err)
+	}
+	defer tty.Close()
+	return stepThrough(tty, os.Stdout, m, s, steps)
+}
+
+// stepThrough implements runTUI, reading commands from in and drawing to out.
+func stepThrough(in io.Reader, out io.Writer, m *maze, s state, steps []step) error {
+	lines := bufio.NewScanner(in)
+	var walked int
+	for i := 0; ; i++ {
+		fmt.Fprint(out, "\x1b[H\x1b[2J")
+		for _, row := range m.render(s.cells, s.keys) {
+			fmt.Fprintf(out, "%s\n", row)
+		}
+		fmt.Fprintln(out)
+		if i > 0 {
+			st := steps[i-1]
+			switch {
+			case st.recall:
+				fmt.Fprintf(out, "move %d of %d: robot %d recalled to start for %d\n", i, len(steps), st.robot+1, st.path.len)
+			case st.path.dest.cellType == start:
+				fmt.Fprintf(out, "move %d of %d: robot %d walked %d back to start\n", i, len(steps), st.robot+1, st.path.len)
+			default:
+				fmt.Fprintf(out, "move %d of %d: robot %d walked %d to key %c\n", i, len(steps), st.robot+1, st.path.len, st.path.dest.char)
+			}
+		}
+		fmt.Fprintf(out, "keys: %s\nsteps: %d\n", s.keys, walked)
+		if i == len(steps) {
+			fmt.Fprintln(out, "done")
+			return nil
+		}
+		fmt.Fprint(out, "[enter] next move, q to quit: ")
+		if !lines.Scan() {
+			return lines.Err()
+		}
+		if strings.TrimSpace(lines.Text()) == "q" {
+			return nil
+		}
+		s = s.next(steps[i])
+		walked += steps[i].path.len
+	}
+}